@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/NX211/traefik-proxmox-provider/provider"
+)
+
+// configEnv maps environment variables to the provider configuration fields they override.
+var configEnv = map[string]func(*provider.Config, string){
+	"API_ENDPOINT":     func(c *provider.Config, v string) { c.ApiEndpoint = v },
+	"API_TOKEN_ID":     func(c *provider.Config, v string) { c.ApiTokenId = v },
+	"API_TOKEN":        func(c *provider.Config, v string) { c.ApiToken = v },
+	"API_LOGGING":      func(c *provider.Config, v string) { c.ApiLogging = v },
+	"API_VALIDATE_SSL": func(c *provider.Config, v string) { c.ApiValidateSSL = v },
+}
+
+// configFlag registers the -config flag shared by all commands.
+func configFlag(fs *flag.FlagSet) *string {
+	return fs.String("config", "", "path to a JSON file with the provider configuration")
+}
+
+// loadConfig builds the provider configuration from the defaults, the optional
+// configuration file and the environment, in that order of precedence.
+func loadConfig(path string) (*provider.Config, error) {
+	config := provider.CreateConfig()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read configuration file: %w", err)
+		}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("failed to parse configuration file %s: %w", path, err)
+		}
+	}
+
+	for name, apply := range configEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			apply(config, value)
+		}
+	}
+
+	return config, nil
+}
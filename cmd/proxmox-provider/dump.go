@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/NX211/traefik-proxmox-provider/dynamic"
+	"github.com/NX211/traefik-proxmox-provider/provider"
+)
+
+func runDump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	configPath := configFlag(fs)
+	format := fs.String("format", "yaml", "output format: yaml or json")
+	_ = fs.Parse(args)
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	p, err := provider.New(ctx, config, "proxmox-provider")
+	if err != nil {
+		return err
+	}
+
+	configuration, err := p.Discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	output, err := encodeConfiguration(configuration, *format)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stdout.Write(output)
+	return err
+}
+
+func encodeConfiguration(configuration *dynamic.Configuration, format string) ([]byte, error) {
+	switch format {
+	case "yaml":
+		return dynamic.MarshalYAML(configuration)
+	case "json":
+		output, err := json.MarshalIndent(configuration, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(output, '\n'), nil
+	default:
+		return nil, fmt.Errorf("unsupported format %q, expected yaml or json", format)
+	}
+}
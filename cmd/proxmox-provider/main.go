@@ -0,0 +1,50 @@
+// Command proxmox-provider runs the Proxmox discovery of the Traefik plugin outside of Traefik,
+// which is mostly useful to debug labels.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `Usage: proxmox-provider <command> [flags]
+
+Commands:
+  dump        Run one discovery pass and print the generated dynamic configuration
+  validate    Check the labels of a single guest and print the routers and services they produce
+  serve       Run the provider standalone and serve the configuration for Traefik's HTTP provider
+
+The provider configuration is read from the file given with -config (JSON, using the
+same keys as the plugin configuration) and from the API_ENDPOINT, API_TOKEN_ID,
+API_TOKEN, API_LOGGING and API_VALIDATE_SSL environment variables.
+
+Run "proxmox-provider <command> -h" for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "dump":
+		err = runDump(os.Args[2:])
+	case "validate":
+		err = runValidate(os.Args[2:])
+	case "serve":
+		err = runServe(os.Args[2:])
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
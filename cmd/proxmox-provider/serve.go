@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/NX211/traefik-proxmox-provider/provider"
+)
+
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := configFlag(fs)
+	listen := fs.String("listen", "", "address to serve the configuration on (defaults to listenAddress from the configuration, or :8081)")
+	_ = fs.Parse(args)
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case *listen != "":
+		config.ListenAddress = *listen
+	case config.ListenAddress == "":
+		config.ListenAddress = ":8081"
+	}
+
+	p, err := provider.New(context.Background(), config, "proxmox-provider")
+	if err != nil {
+		return err
+	}
+
+	// The configuration is served by the internal listener on /config, the
+	// channel only needs to be drained.
+	cfgChan := make(chan json.Marshaler)
+	if err := p.Provide(cfgChan); err != nil {
+		return err
+	}
+	log.Printf("Serving dynamic configuration on http://%s/config", config.ListenAddress)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	for {
+		select {
+		case <-cfgChan:
+		case sig := <-signals:
+			log.Printf("Received %s, shutting down", sig)
+			return p.Stop()
+		}
+	}
+}
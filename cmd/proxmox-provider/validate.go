@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/dynamic"
+	"github.com/NX211/traefik-proxmox-provider/provider"
+)
+
+func runValidate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := configFlag(fs)
+	vmID := fs.Uint64("vmid", 0, "ID of the VM or container to validate")
+	format := fs.String("format", "text", "output format: text or json")
+	_ = fs.Parse(args)
+
+	if *vmID == 0 {
+		return errors.New("the -vmid flag is required")
+	}
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	p, err := provider.New(ctx, config, "proxmox-provider")
+	if err != nil {
+		return err
+	}
+
+	report, err := p.InspectGuest(ctx, *vmID)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "text":
+		err = writeReport(os.Stdout, report)
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	default:
+		err = fmt.Errorf("unsupported format %q, expected text or json", *format)
+	}
+	if err != nil {
+		return err
+	}
+
+	if report.HasProblems() {
+		return fmt.Errorf("labels of guest %d contain errors", *vmID)
+	}
+	return nil
+}
+
+func writeReport(w io.Writer, report *provider.GuestReport) error {
+	fmt.Fprintf(w, "Guest:   %s (%d, %s) on node %s\n", report.Name, report.VMID, report.Type, report.Node)
+	fmt.Fprintf(w, "Status:  %s\n", report.Status)
+	fmt.Fprintf(w, "Enabled: %t\n", report.Enabled)
+	if len(report.Tags) > 0 {
+		fmt.Fprintf(w, "Tags: %s\n", strings.Join(report.Tags, ", "))
+	}
+
+	fmt.Fprintf(w, "IPs:    ")
+	if len(report.IPs) == 0 {
+		fmt.Fprintf(w, " none (the hostname fallback will be used)")
+	}
+	for _, ip := range report.IPs {
+		fmt.Fprintf(w, " %s", ip.Address)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintf(w, "\nLabels (%d):\n", len(report.Labels))
+	keys := make([]string, 0, len(report.Labels))
+	for key := range report.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "  %s=%s\n", key, report.Labels[key])
+	}
+
+	if report.HasProblems() {
+		fmt.Fprintln(w, "\nProblems:")
+		if report.DecodeError != "" {
+			fmt.Fprintf(w, "  decode error: %s\n", report.DecodeError)
+		}
+		for _, key := range report.UnknownKeys {
+			fmt.Fprintf(w, "  unknown key: %s\n", key)
+		}
+		invalid := make([]string, 0, len(report.InvalidLabels))
+		for key := range report.InvalidLabels {
+			invalid = append(invalid, key)
+		}
+		sort.Strings(invalid)
+		for _, key := range invalid {
+			fmt.Fprintf(w, "  invalid value for %s: %s\n", key, report.InvalidLabels[key])
+		}
+	} else {
+		fmt.Fprintln(w, "\nNo problems found.")
+	}
+
+	output, err := dynamic.MarshalYAML(report.Configuration)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\nResulting configuration:\n%s", output)
+	return nil
+}
@@ -0,0 +1,128 @@
+package dynamic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// MarshalTOML encodes a value as TOML using its JSON field names, so the output
+// matches the layout expected by Traefik's file provider.
+func MarshalTOML(v interface{}) ([]byte, error) {
+	generic, err := toGeneric(v)
+	if err != nil {
+		return nil, err
+	}
+
+	root, ok := generic.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("TOML documents must be tables, got %T", generic)
+	}
+
+	var b bytes.Buffer
+	writeTOMLTable(&b, nil, root, false)
+	return b.Bytes(), nil
+}
+
+// writeTOMLTable writes the table found at path: its scalar values first, then its sub-tables
+// and arrays of tables. When arrayItem is set the header is written as [[path]].
+func writeTOMLTable(b *bytes.Buffer, path []string, table map[string]interface{}, arrayItem bool) {
+	keys := sortedMapKeys(table)
+
+	if len(path) > 0 {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		if arrayItem {
+			fmt.Fprintf(b, "[[%s]]\n", tomlPath(path))
+		} else {
+			fmt.Fprintf(b, "[%s]\n", tomlPath(path))
+		}
+	}
+
+	for _, key := range keys {
+		switch value := table[key].(type) {
+		case nil, map[string]interface{}:
+			continue
+		case []interface{}:
+			if isTableArray(value) {
+				continue
+			}
+			fmt.Fprintf(b, "%s = %s\n", tomlKey(key), tomlValue(value))
+		default:
+			fmt.Fprintf(b, "%s = %s\n", tomlKey(key), tomlValue(value))
+		}
+	}
+
+	for _, key := range keys {
+		childPath := append(append([]string{}, path...), key)
+		switch value := table[key].(type) {
+		case map[string]interface{}:
+			writeTOMLTable(b, childPath, value, false)
+		case []interface{}:
+			if !isTableArray(value) {
+				continue
+			}
+			for _, item := range value {
+				writeTOMLTable(b, childPath, item.(map[string]interface{}), true)
+			}
+		}
+	}
+}
+
+// isTableArray reports whether a list is a non-empty list of tables.
+func isTableArray(list []interface{}) bool {
+	if len(list) == 0 {
+		return false
+	}
+	for _, item := range list {
+		if _, ok := item.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func tomlPath(path []string) string {
+	keys := make([]string, len(path))
+	for i, key := range path {
+		keys[i] = tomlKey(key)
+	}
+	return strings.Join(keys, ".")
+}
+
+func tomlKey(key string) string {
+	if key == "" {
+		return `""`
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			quoted, _ := json.Marshal(key)
+			return string(quoted)
+		}
+	}
+	return key
+}
+
+func tomlValue(v interface{}) string {
+	switch value := v.(type) {
+	case []interface{}:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			items = append(items, tomlValue(item))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]interface{}:
+		items := make([]string, 0, len(value))
+		for _, key := range sortedMapKeys(value) {
+			items = append(items, fmt.Sprintf("%s = %s", tomlKey(key), tomlValue(value[key])))
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	case nil:
+		return `""`
+	default:
+		// Strings, numbers and booleans share their encoding with YAML.
+		return yamlScalar(value)
+	}
+}
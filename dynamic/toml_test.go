@@ -0,0 +1,52 @@
+package dynamic
+
+import (
+	"testing"
+)
+
+func TestMarshalTOML(t *testing.T) {
+	passHostHeader := true
+	config := &Configuration{
+		HTTP: &HTTPConfiguration{
+			Routers: map[string]*Router{
+				"web": {Rule: "Host(`web.example.com`)", EntryPoints: []string{"websecure"}},
+			},
+			Services: map[string]*Service{
+				"web": {LoadBalancer: &ServersLoadBalancer{
+					PassHostHeader: &passHostHeader,
+					Servers:        []Server{{URL: "http://10.0.0.5:8080"}, {URL: "http://10.0.0.6:8080"}},
+				}},
+			},
+		},
+	}
+
+	output, err := MarshalTOML(config)
+	if err != nil {
+		t.Fatalf("MarshalTOML() error = %v", err)
+	}
+
+	expected := `[http]
+
+[http.routers]
+
+[http.routers.web]
+entryPoints = ["websecure"]
+rule = "Host(` + "`web.example.com`" + `)"
+
+[http.services]
+
+[http.services.web]
+
+[http.services.web.loadBalancer]
+passHostHeader = true
+
+[[http.services.web.loadBalancer.servers]]
+url = "http://10.0.0.5:8080"
+
+[[http.services.web.loadBalancer.servers]]
+url = "http://10.0.0.6:8080"
+`
+	if string(output) != expected {
+		t.Errorf("MarshalTOML() =\n%s\nwant\n%s", output, expected)
+	}
+}
@@ -0,0 +1,463 @@
+package dynamic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MarshalYAML encodes a value as YAML using its JSON field names, so the output
+// matches the layout expected by Traefik's file provider.
+func MarshalYAML(v interface{}) ([]byte, error) {
+	generic, err := toGeneric(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	switch value := generic.(type) {
+	case map[string]interface{}:
+		if len(value) == 0 {
+			b.WriteString("{}\n")
+			break
+		}
+		writeYAMLMap(&b, value, 0, false)
+	case []interface{}:
+		if len(value) == 0 {
+			b.WriteString("[]\n")
+			break
+		}
+		writeYAMLList(&b, value, 0)
+	default:
+		b.WriteString(yamlScalar(value))
+		b.WriteString("\n")
+	}
+	return b.Bytes(), nil
+}
+
+// toGeneric converts a value into maps, slices and scalars by round-tripping through JSON,
+// which applies the json struct tags (names and omitempty).
+func toGeneric(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal configuration: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, fmt.Errorf("failed to decode configuration: %w", err)
+	}
+	return generic, nil
+}
+
+// writeYAMLMap writes the entries of m. When inline is set, the first key continues
+// the current "- " line of a list item instead of starting a new line.
+func writeYAMLMap(b *bytes.Buffer, m map[string]interface{}, indent int, inline bool) {
+	for i, key := range sortedMapKeys(m) {
+		if i > 0 || !inline {
+			b.WriteString(strings.Repeat(" ", indent))
+		}
+		b.WriteString(yamlKey(key))
+		b.WriteString(":")
+		writeYAMLChild(b, m[key], indent)
+	}
+}
+
+func writeYAMLList(b *bytes.Buffer, list []interface{}, indent int) {
+	for _, item := range list {
+		b.WriteString(strings.Repeat(" ", indent))
+		b.WriteString("- ")
+		switch value := item.(type) {
+		case map[string]interface{}:
+			if len(value) == 0 {
+				b.WriteString("{}\n")
+				continue
+			}
+			writeYAMLMap(b, value, indent+2, true)
+		case []interface{}:
+			if len(value) == 0 {
+				b.WriteString("[]\n")
+				continue
+			}
+			b.WriteString("\n")
+			writeYAMLList(b, value, indent+2)
+		default:
+			b.WriteString(yamlScalar(value))
+			b.WriteString("\n")
+		}
+	}
+}
+
+func writeYAMLChild(b *bytes.Buffer, v interface{}, indent int) {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		if len(value) == 0 {
+			b.WriteString(" {}\n")
+			return
+		}
+		b.WriteString("\n")
+		writeYAMLMap(b, value, indent+2, false)
+	case []interface{}:
+		if len(value) == 0 {
+			b.WriteString(" []\n")
+			return
+		}
+		b.WriteString("\n")
+		writeYAMLList(b, value, indent+2)
+	default:
+		b.WriteString(" ")
+		b.WriteString(yamlScalar(value))
+		b.WriteString("\n")
+	}
+}
+
+func yamlKey(key string) string {
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' || r == '@') {
+			return yamlScalar(key)
+		}
+	}
+	if key == "" {
+		return `""`
+	}
+	return key
+}
+
+func yamlScalar(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		if value {
+			return "true"
+		}
+		return "false"
+	case json.Number:
+		return value.String()
+	case string:
+		// JSON strings are valid YAML double-quoted scalars.
+		quoted, _ := json.Marshal(value)
+		return string(quoted)
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
+
+func sortedMapKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// UnmarshalYAML decodes the YAML subset written by MarshalYAML (block mappings and lists,
+// flow lists, quoted and plain scalars, comments) into v, using its JSON field names.
+func UnmarshalYAML(data []byte, v interface{}) error {
+	generic, err := parseYAML(string(data))
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(generic)
+	if err != nil {
+		return fmt.Errorf("failed to encode YAML document: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// yamlLine is a non-blank line of a YAML document, stripped of its indentation and comment.
+type yamlLine struct {
+	number  int
+	indent  int
+	content string
+}
+
+func parseYAML(text string) (interface{}, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(text, "\n") {
+		raw = strings.TrimRight(raw, "\r")
+		if strings.TrimSpace(raw) == "---" {
+			continue
+		}
+		content := strings.TrimSpace(stripYAMLComment(raw))
+		if content == "" {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimLeft(raw, " "), "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+		}
+		indent := len(raw) - len(strings.TrimLeft(raw, " "))
+		lines = append(lines, yamlLine{number: i + 1, indent: indent, content: content})
+	}
+	if len(lines) == 0 {
+		return map[string]interface{}{}, nil
+	}
+
+	value, next, err := parseYAMLBlock(lines, 0, lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if next < len(lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", lines[next].number)
+	}
+	return value, nil
+}
+
+// parseYAMLBlock parses the mapping or list starting at lines[i] and returns the index of the
+// first line that doesn't belong to it.
+func parseYAMLBlock(lines []yamlLine, i, indent int) (interface{}, int, error) {
+	if isYAMLListItem(lines[i].content) {
+		return parseYAMLList(lines, i, indent)
+	}
+	return parseYAMLMap(lines, i, indent)
+}
+
+func parseYAMLMap(lines []yamlLine, i, indent int) (interface{}, int, error) {
+	m := make(map[string]interface{})
+	for i < len(lines) && lines[i].indent == indent {
+		line := lines[i]
+		if isYAMLListItem(line.content) {
+			return nil, i, fmt.Errorf("line %d: unexpected list item", line.number)
+		}
+		key, rest, ok := splitYAMLKey(line.content)
+		if !ok {
+			return nil, i, fmt.Errorf("line %d: expected \"key: value\", got %q", line.number, line.content)
+		}
+		i++
+
+		if rest != "" {
+			value, err := parseYAMLValue(rest)
+			if err != nil {
+				return nil, i, fmt.Errorf("line %d: %w", line.number, err)
+			}
+			m[key] = value
+			continue
+		}
+
+		switch {
+		case i < len(lines) && lines[i].indent > indent:
+			value, next, err := parseYAMLBlock(lines, i, lines[i].indent)
+			if err != nil {
+				return nil, next, err
+			}
+			m[key], i = value, next
+		case i < len(lines) && lines[i].indent == indent && isYAMLListItem(lines[i].content):
+			// Lists may start at the indentation of their key.
+			value, next, err := parseYAMLList(lines, i, indent)
+			if err != nil {
+				return nil, next, err
+			}
+			m[key], i = value, next
+		default:
+			m[key] = nil
+		}
+	}
+	if i < len(lines) && lines[i].indent > indent {
+		return nil, i, fmt.Errorf("line %d: unexpected indentation", lines[i].number)
+	}
+	return m, i, nil
+}
+
+func parseYAMLList(lines []yamlLine, i, indent int) (interface{}, int, error) {
+	list := make([]interface{}, 0)
+	for i < len(lines) && lines[i].indent == indent && isYAMLListItem(lines[i].content) {
+		line := lines[i]
+		rest := strings.TrimLeft(line.content[1:], " ")
+
+		switch {
+		case rest == "":
+			i++
+			if i < len(lines) && lines[i].indent > indent {
+				value, next, err := parseYAMLBlock(lines, i, lines[i].indent)
+				if err != nil {
+					return nil, next, err
+				}
+				list, i = append(list, value), next
+				continue
+			}
+			list = append(list, nil)
+		case isYAMLListItem(rest) || isYAMLMapEntry(rest):
+			// The item is a nested block starting on the "- " line itself: parse it as if
+			// it were on its own line, indented to its first character.
+			lines[i] = yamlLine{number: line.number, indent: indent + len(line.content) - len(rest), content: rest}
+			value, next, err := parseYAMLBlock(lines, i, lines[i].indent)
+			if err != nil {
+				return nil, next, err
+			}
+			list, i = append(list, value), next
+		default:
+			value, err := parseYAMLValue(rest)
+			if err != nil {
+				return nil, i, fmt.Errorf("line %d: %w", line.number, err)
+			}
+			list = append(list, value)
+			i++
+		}
+	}
+	return list, i, nil
+}
+
+func isYAMLListItem(content string) bool {
+	return content == "-" || strings.HasPrefix(content, "- ")
+}
+
+func isYAMLMapEntry(content string) bool {
+	_, _, ok := splitYAMLKey(content)
+	return ok
+}
+
+// splitYAMLKey splits a "key: value" entry. The colon must be followed by a space or end the line,
+// so values such as URLs are not mistaken for entries.
+func splitYAMLKey(content string) (string, string, bool) {
+	if content[0] == '"' || content[0] == '\'' {
+		key, rest, err := parseYAMLQuoted(content)
+		if err != nil || !strings.HasPrefix(rest, ":") || (len(rest) > 1 && rest[1] != ' ') {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(rest[1:]), true
+	}
+	if content[0] == '[' || content[0] == '{' {
+		return "", "", false
+	}
+
+	for i := 0; i < len(content); i++ {
+		if content[i] == ':' && (i == len(content)-1 || content[i+1] == ' ') {
+			return strings.TrimSpace(content[:i]), strings.TrimSpace(content[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// parseYAMLValue parses an inline value: a flow list, an empty flow mapping or a scalar.
+func parseYAMLValue(text string) (interface{}, error) {
+	switch {
+	case text == "|" || text == ">" || strings.HasPrefix(text, "|") || strings.HasPrefix(text, ">"):
+		return nil, fmt.Errorf("block scalars are not supported")
+	case text == "{}":
+		return map[string]interface{}{}, nil
+	case strings.HasPrefix(text, "{"):
+		return nil, fmt.Errorf("flow mappings are not supported")
+	case strings.HasPrefix(text, "["):
+		return parseYAMLFlowList(text)
+	case text[0] == '"' || text[0] == '\'':
+		value, rest, err := parseYAMLQuoted(text)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(rest) != "" {
+			return nil, fmt.Errorf("unexpected %q after quoted string", rest)
+		}
+		return value, nil
+	default:
+		return yamlPlainScalar(text), nil
+	}
+}
+
+func parseYAMLFlowList(text string) (interface{}, error) {
+	if !strings.HasSuffix(text, "]") {
+		return nil, fmt.Errorf("unterminated flow list %q", text)
+	}
+	inner := strings.TrimSpace(text[1 : len(text)-1])
+
+	list := make([]interface{}, 0)
+	for inner != "" {
+		var item interface{}
+		if inner[0] == '"' || inner[0] == '\'' {
+			value, rest, err := parseYAMLQuoted(inner)
+			if err != nil {
+				return nil, err
+			}
+			item, inner = value, strings.TrimSpace(rest)
+		} else {
+			end := strings.IndexByte(inner, ',')
+			if end < 0 {
+				end = len(inner)
+			}
+			item, inner = yamlPlainScalar(strings.TrimSpace(inner[:end])), inner[end:]
+		}
+		list = append(list, item)
+
+		if inner == "" {
+			break
+		}
+		if inner[0] != ',' {
+			return nil, fmt.Errorf("expected \",\" in flow list %q", text)
+		}
+		inner = strings.TrimSpace(inner[1:])
+	}
+	return list, nil
+}
+
+// parseYAMLQuoted parses the quoted string at the start of text and returns the rest of the text.
+func parseYAMLQuoted(text string) (string, string, error) {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case quote == '\'' && text[i] == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == quote:
+			if quote == '\'' {
+				return strings.ReplaceAll(text[1:i], "''", "'"), text[i+1:], nil
+			}
+			var value string
+			if err := json.Unmarshal([]byte(text[:i+1]), &value); err != nil {
+				return "", "", fmt.Errorf("invalid quoted string %s", text[:i+1])
+			}
+			return value, text[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("unterminated quoted string %s", text)
+}
+
+func yamlPlainScalar(text string) interface{} {
+	switch text {
+	case "", "~", "null":
+		return nil
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	var number json.Number
+	if err := json.Unmarshal([]byte(text), &number); err == nil {
+		return number
+	}
+	return text
+}
+
+// stripYAMLComment removes a trailing comment, i.e. a "#" outside quotes at the start of the
+// line or preceded by a space.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			// Quotes only open a string at the start of a scalar.
+			if i == 0 || line[i-1] == ' ' || line[i-1] == '[' || line[i-1] == ',' {
+				quote = c
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' '):
+			return line[:i]
+		}
+	}
+	return line
+}
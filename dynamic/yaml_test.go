@@ -0,0 +1,101 @@
+package dynamic
+
+import (
+	"testing"
+)
+
+func TestMarshalYAML(t *testing.T) {
+	passHostHeader := true
+	config := &Configuration{
+		HTTP: &HTTPConfiguration{
+			Routers: map[string]*Router{
+				"web": {Rule: "Host(`web.example.com`)", EntryPoints: []string{"websecure"}},
+			},
+			Services: map[string]*Service{
+				"web": {LoadBalancer: &ServersLoadBalancer{
+					PassHostHeader: &passHostHeader,
+					Servers:        []Server{{URL: "http://10.0.0.5:8080"}},
+				}},
+			},
+		},
+	}
+
+	output, err := MarshalYAML(config)
+	if err != nil {
+		t.Fatalf("MarshalYAML() error = %v", err)
+	}
+
+	expected := `http:
+  routers:
+    web:
+      entryPoints:
+        - "websecure"
+      rule: "Host(` + "`web.example.com`" + `)"
+  services:
+    web:
+      loadBalancer:
+        passHostHeader: true
+        servers:
+          - url: "http://10.0.0.5:8080"
+`
+	if string(output) != expected {
+		t.Errorf("MarshalYAML() =\n%s\nwant\n%s", output, expected)
+	}
+}
+
+func TestUnmarshalYAML(t *testing.T) {
+	input := `# Written by hand
+http:
+  routers:
+    web:
+      rule: "Host(` + "`web.example.com`" + `)" # the public name
+      entryPoints: [websecure, "web"]
+      tls: {}
+  services:
+    web:
+      loadBalancer:
+        passHostHeader: false
+        servers:
+        - url: http://10.0.0.5:8080
+          weight: 2
+`
+
+	var config Configuration
+	if err := UnmarshalYAML([]byte(input), &config); err != nil {
+		t.Fatalf("UnmarshalYAML() error = %v", err)
+	}
+
+	router := config.HTTP.Routers["web"]
+	if router == nil || router.Rule != "Host(`web.example.com`)" {
+		t.Fatalf("Expected the web router rule, got %+v", router)
+	}
+	if len(router.EntryPoints) != 2 || router.EntryPoints[0] != "websecure" || router.EntryPoints[1] != "web" {
+		t.Errorf("Expected entry points [websecure web], got %v", router.EntryPoints)
+	}
+	if router.TLS == nil {
+		t.Error("Expected TLS to be enabled")
+	}
+
+	loadBalancer := config.HTTP.Services["web"].LoadBalancer
+	if loadBalancer.PassHostHeader == nil || *loadBalancer.PassHostHeader {
+		t.Errorf("Expected passHostHeader false, got %v", loadBalancer.PassHostHeader)
+	}
+	if len(loadBalancer.Servers) != 1 || loadBalancer.Servers[0].URL != "http://10.0.0.5:8080" {
+		t.Errorf("Expected one server at http://10.0.0.5:8080, got %+v", loadBalancer.Servers)
+	}
+}
+
+func TestUnmarshalYAML_Errors(t *testing.T) {
+	tests := []string{
+		"http:\n  routers:\n     web: {}\n    api: {}",
+		"rule: \"unterminated",
+		"description: |\n  text",
+		"just a scalar line",
+	}
+	for _, input := range tests {
+		var v interface{}
+		if err := UnmarshalYAML([]byte(input), &v); err == nil {
+			t.Errorf("UnmarshalYAML(%q) expected an error, got %v", input, v)
+		}
+	}
+}
@@ -4,4 +4,4 @@ go 1.22.0
 
 toolchain go1.24.4
 
-require github.com/traefik/paerser v0.2.2 // indirect
+require github.com/traefik/paerser v0.2.2
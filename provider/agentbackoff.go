@@ -0,0 +1,124 @@
+package provider
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// agentFailureThreshold is the number of failed agent queries in a row after which a guest is backed off.
+	agentFailureThreshold = 3
+	// minAgentBackoff is the first backoff of a guest, doubled on every failed retry up to maxAgentBackoff.
+	minAgentBackoff = 2 * time.Minute
+	maxAgentBackoff = 30 * time.Minute
+)
+
+// errAgentBackoff is returned instead of querying the agent of a backed off guest.
+var errAgentBackoff = errors.New("guest agent backed off after failing repeatedly")
+
+// AgentBackoff tracks the VMs whose guest agent queries keep failing, typically because no agent is installed,
+// so their agent is only queried again once in a while instead of on every poll. A nil backoff always queries.
+type AgentBackoff struct {
+	mu     sync.Mutex
+	guests map[uint64]*agentFailures
+	now    func() time.Time
+}
+
+type agentFailures struct {
+	count int
+	// uptime is the uptime of the guest at its last failure, a lower uptime means it was restarted since.
+	uptime  uint64
+	retryAt time.Time
+}
+
+// NewAgentBackoff creates a backoff without failures.
+func NewAgentBackoff() *AgentBackoff {
+	return &AgentBackoff{guests: make(map[uint64]*agentFailures), now: time.Now}
+}
+
+// allows reports whether the agent of a guest may be queried. A guest restarted since its last failure is
+// queried again right away, as its agent may have been installed meanwhile.
+func (b *AgentBackoff) allows(vmID, uptime uint64) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	failures, ok := b.guests[vmID]
+	if !ok {
+		return true
+	}
+	if uptime < failures.uptime {
+		delete(b.guests, vmID)
+		return true
+	}
+	return failures.count < agentFailureThreshold || !b.now().Before(failures.retryAt)
+}
+
+// query runs an agent call of a guest unless the guest is backed off, and records its outcome.
+func (b *AgentBackoff) query(vmID, uptime uint64, call func() error) error {
+	if !b.allows(vmID, uptime) {
+		return errAgentBackoff
+	}
+	err := call()
+	b.record(vmID, uptime, err)
+	return err
+}
+
+// record stores the outcome of an agent query.
+func (b *AgentBackoff) record(vmID, uptime uint64, err error) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	failures, ok := b.guests[vmID]
+	if err == nil {
+		if ok && failures.count >= agentFailureThreshold {
+			log.Printf("Guest agent of VM %d answers again", vmID)
+		}
+		delete(b.guests, vmID)
+		return
+	}
+
+	if !ok {
+		failures = &agentFailures{}
+		b.guests[vmID] = failures
+	}
+	failures.count++
+	failures.uptime = uptime
+	if failures.count < agentFailureThreshold {
+		return
+	}
+
+	backoff := maxAgentBackoff
+	if retries := failures.count - agentFailureThreshold; retries < 4 {
+		backoff = minAgentBackoff << uint(retries)
+	}
+	if backoff > maxAgentBackoff {
+		backoff = maxAgentBackoff
+	}
+	failures.retryAt = b.now().Add(backoff)
+	log.Printf("Guest agent of VM %d failed %d times in a row (%v), querying it again in %s", vmID, failures.count, err, backoff)
+}
+
+// prune drops the failures of the guests that aren't in seen.
+func (b *AgentBackoff) prune(seen map[uint64]bool) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for vmID := range b.guests {
+		if !seen[vmID] {
+			delete(b.guests, vmID)
+		}
+	}
+}
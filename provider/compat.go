@@ -0,0 +1,127 @@
+package provider
+
+import (
+	"log"
+
+	"github.com/NX211/traefik-proxmox-provider/dynamic"
+)
+
+// Traefik versions accepted by the traefikVersion option.
+const (
+	traefikV2 = "v2"
+	traefikV3 = "v3"
+)
+
+// adaptToTraefikVersion makes a configuration valid for a Traefik version. For v2 it removes the fields added
+// in v3, which Traefik v2.11 doesn't know. For v3 it moves the ipWhiteList middlewares to ipAllowList and
+// removes the fields v3 dropped. Other versions leave the configuration unchanged.
+func adaptToTraefikVersion(config *dynamic.Configuration, version string) {
+	switch version {
+	case traefikV2:
+		adaptToTraefikV2(config)
+	case traefikV3:
+		adaptToTraefikV3(config)
+	}
+}
+
+func adaptToTraefikV2(config *dynamic.Configuration) {
+	for name, router := range config.HTTP.Routers {
+		if router.RuleSyntax != "" || router.Observability != nil {
+			log.Printf("Dropping the ruleSyntax and observability of router %s, Traefik v2 doesn't support them", name)
+			router.RuleSyntax = ""
+			router.Observability = nil
+		}
+	}
+	for _, router := range config.TCP.Routers {
+		router.RuleSyntax = ""
+	}
+
+	for name, service := range config.HTTP.Services {
+		lb := service.LoadBalancer
+		if lb == nil {
+			continue
+		}
+		if lb.Strategy != "" || lb.PassiveHealthCheck != nil {
+			log.Printf("Dropping the strategy and passive health check of service %s, Traefik v2 doesn't support them", name)
+			lb.Strategy = ""
+			lb.PassiveHealthCheck = nil
+		}
+		if lb.HealthCheck != nil {
+			lb.HealthCheck.Mode = ""
+		}
+		// Traefik v2 always keeps the path of server URLs.
+		for i := range lb.Servers {
+			lb.Servers[i].PreservePath = false
+		}
+	}
+
+	dropped := make(map[string]bool)
+	for name, middleware := range config.HTTP.Middlewares {
+		if middleware.GrpcWeb != nil {
+			log.Printf("Dropping the grpcWeb middleware %s, Traefik v2 doesn't support it", name)
+			delete(config.HTTP.Middlewares, name)
+			dropped[name] = true
+			continue
+		}
+		if middleware.IPAllowList != nil {
+			middleware.IPAllowList.RejectStatusCode = 0
+		}
+		if middleware.Errors != nil {
+			middleware.Errors.StatusRewrites = nil
+		}
+	}
+	if len(dropped) == 0 {
+		return
+	}
+	for _, router := range config.HTTP.Routers {
+		middlewares := router.Middlewares[:0]
+		for _, middleware := range router.Middlewares {
+			if !dropped[middleware] {
+				middlewares = append(middlewares, middleware)
+			}
+		}
+		router.Middlewares = middlewares
+	}
+}
+
+func adaptToTraefikV3(config *dynamic.Configuration) {
+	for name, middleware := range config.HTTP.Middlewares {
+		if middleware.IPWhiteList != nil {
+			if middleware.IPAllowList == nil {
+				middleware.IPAllowList = &dynamic.IPAllowList{
+					SourceRange: middleware.IPWhiteList.SourceRange,
+					IPStrategy:  middleware.IPWhiteList.IPStrategy,
+				}
+			}
+			middleware.IPWhiteList = nil
+		}
+		if headers := middleware.Headers; headers != nil {
+			if headers.FeaturePolicy != nil && headers.PermissionsPolicy == "" {
+				headers.PermissionsPolicy = *headers.FeaturePolicy
+			}
+			if headers.SSLRedirect != nil || headers.SSLTemporaryRedirect != nil || headers.SSLHost != nil || headers.SSLForceHost != nil {
+				log.Printf("Dropping the SSL redirect of headers middleware %s, Traefik v3 removed it in favor of the redirectScheme middleware", name)
+			}
+			headers.FeaturePolicy = nil
+			headers.SSLRedirect = nil
+			headers.SSLTemporaryRedirect = nil
+			headers.SSLHost = nil
+			headers.SSLForceHost = nil
+		}
+		if middleware.StripPrefix != nil {
+			middleware.StripPrefix.ForceSlash = nil
+		}
+		if middleware.ContentType != nil {
+			middleware.ContentType.AutoDetect = nil
+		}
+	}
+
+	for _, middleware := range config.TCP.Middlewares {
+		if middleware.IPWhiteList != nil {
+			if middleware.IPAllowList == nil {
+				middleware.IPAllowList = &dynamic.TCPIPAllowList{SourceRange: middleware.IPWhiteList.SourceRange}
+			}
+			middleware.IPWhiteList = nil
+		}
+	}
+}
@@ -1,18 +1,741 @@
 package provider
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/NX211/traefik-proxmox-provider/dynamic"
-	"github.com/NX211/traefik-proxmox-provider/internal"
+	"github.com/NX211/traefik-proxmox-provider/dynamic/tls"
+	"github.com/NX211/traefik-proxmox-provider/dynamic/types"
+	"github.com/NX211/traefik-proxmox-provider/proxmox"
 	"github.com/traefik/paerser/parser"
 )
 
-// creates the final dynamic configuration by processing all discovered services and their labels
-func generateConfiguration(servicesMap map[string][]internal.Service) *dynamic.Configuration {
-	config := &dynamic.Configuration{
+// ConfigurationOptions tune the configuration generated from the discovered services.
+type ConfigurationOptions struct {
+	// Strict replaces the configuration of a guest whose labels can't be decoded with an error
+	// router, so the misconfiguration shows up in the Traefik dashboard instead of only in the logs.
+	Strict bool
+	// DefaultEntryPoints are set on the HTTP routers that don't list any entry points.
+	DefaultEntryPoints []string
+	// PortEntryPoints maps server ports to the entry point of the HTTP and TCP routers of a guest that don't
+	// list any entry points, e.g. 443 to websecure.
+	PortEntryPoints map[string]string
+	// DefaultMiddlewares are appended to the middlewares of the HTTP routers of the guests that don't opt out.
+	DefaultMiddlewares []string
+	// DefaultCertResolver is the certificate resolver of the HTTP routers that don't set one, enabling TLS on them.
+	DefaultCertResolver string
+	// DefaultTLSDomain and DefaultTLSSANs are the certificate domain of the HTTP routers with TLS that don't list
+	// any domains, e.g. example.com and *.example.com to request one wildcard certificate with a DNS challenge.
+	DefaultTLSDomain string
+	DefaultTLSSANs   []string
+	// HTTPSRedirect adds a router redirecting to HTTPS next to every HTTP router with TLS, on the
+	// HTTPSRedirectEntryPoint (web by default).
+	HTTPSRedirect           bool
+	HTTPSRedirectEntryPoint string
+	// DefaultTCPMiddlewares are attached to the TCP routers that don't list any middlewares.
+	DefaultTCPMiddlewares []string
+	// DefaultHealthCheck is set on the HTTP load balancers that don't configure a health check.
+	DefaultHealthCheck *dynamic.ServerHealthCheck
+	// DefaultSticky is set on the HTTP load balancers with several servers that don't configure sticky sessions.
+	DefaultSticky *dynamic.Sticky
+	// AllIPs creates a server per guest IP instead of one for the first IP.
+	AllIPs bool
+	// MaintenanceURL is the page shown by the routers of guests in maintenance instead of a bare 503.
+	MaintenanceURL string
+	// Namespace prefixes the names defined in the labels of each guest with its vmid or node.
+	Namespace string
+	// LegacyPriority sets priority 1 on the routers without a priority, as earlier releases did.
+	LegacyPriority bool
+	// DisablePassHostHeader turns passHostHeader off on the HTTP load balancers that don't set it.
+	DisablePassHostHeader bool
+	// Protocols limits the generated configuration to http, tcp or udp, all of them when empty. The labels of
+	// the other protocols are ignored and their sections are left out.
+	Protocols []string
+	// TraefikVersion adapts the configuration to Traefik v2 or v3, see adaptToTraefikVersion.
+	TraefikVersion string
+	// ProxmoxUIHost exposes the web interface of the nodes on this host, with the consoles of the guests
+	// when ProxmoxUIConsoles is set and SPICE on the SpiceEntryPoint, see buildProxmoxUIConfiguration.
+	// NodeAddresses are the addresses of the nodes, by name.
+	ProxmoxUIHost     string
+	ProxmoxUIConsoles bool
+	SpiceEntryPoint   string
+	NodeAddresses     map[string]string
+	// DecodeCache reuses the decoded labels of the guests whose labels didn't change since the previous
+	// pass. Nil decodes the labels of every guest.
+	DecodeCache *DecodeCache
+}
+
+// generates reports whether the configuration of a protocol is generated.
+func (o ConfigurationOptions) generates(protocol string) bool {
+	if len(o.Protocols) == 0 {
+		return true
+	}
+	for _, enabled := range o.Protocols {
+		if strings.EqualFold(enabled, protocol) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHealthCheck reads a health check from keys named like the loadbalancer.healthcheck.* labels,
+// e.g. path, interval or headers.X-Probe.
+func parseHealthCheck(settings map[string]string) (*dynamic.ServerHealthCheck, error) {
+	if len(settings) == 0 {
+		return nil, nil
+	}
+	healthCheck := &dynamic.ServerHealthCheck{}
+	if err := decodeSettings(settings, healthCheck); err != nil {
+		return nil, err
+	}
+	return healthCheck, nil
+}
+
+// parseSticky reads a sticky session configuration from keys named like the loadbalancer.sticky.* labels,
+// e.g. cookie.name or cookie.secure.
+func parseSticky(settings map[string]string) (*dynamic.Sticky, error) {
+	if len(settings) == 0 {
+		return nil, nil
+	}
+	sticky := &dynamic.Sticky{}
+	if err := decodeSettings(settings, sticky); err != nil {
+		return nil, err
+	}
+	return sticky, nil
+}
+
+// decodeSettings decodes provider settings written like the labels of element, without their prefix.
+func decodeSettings(settings map[string]string, element interface{}) error {
+	labels := make(map[string]string, len(settings))
+	for key, value := range settings {
+		labels["traefik."+key] = value
+	}
+	return parser.Decode(labels, element, "traefik", "traefik")
+}
+
+// GuestError is a configuration error of a guest, found while generating the configuration.
+type GuestError struct {
+	Node  string `json:"node"`
+	VMID  uint64 `json:"vmid"`
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// GenerateConfiguration creates the final dynamic configuration by processing all discovered services
+// (as returned by GetServiceMap) and their labels.
+func GenerateConfiguration(servicesMap map[string][]proxmox.Service) *dynamic.Configuration {
+	config, _ := BuildConfiguration(servicesMap, ConfigurationOptions{})
+	return config
+}
+
+// BuildConfiguration is GenerateConfiguration with options. It also returns the configuration errors
+// of the guests, sorted by node and VMID.
+func BuildConfiguration(servicesMap map[string][]proxmox.Service, opts ConfigurationOptions) (*dynamic.Configuration, []GuestError) {
+	nodeNames := make([]string, 0, len(servicesMap))
+	for nodeName := range servicesMap {
+		nodeNames = append(nodeNames, nodeName)
+	}
+	sort.Strings(nodeNames)
+
+	builder := newConfigurationBuilder(opts)
+	for _, nodeName := range nodeNames {
+		builder.addNode(nodeName, servicesMap[nodeName])
+	}
+	return builder.build()
+}
+
+// configurationBuilder builds the configuration of the guests node by node, as the nodes are scanned, so the
+// services of a node don't have to be kept once it was added. Only the configuration built for each guest is
+// kept until build merges them, once every node was added: the duplicates of migrating guests are only known
+// then, and merging the guests in the order of their nodes and VMIDs keeps the first member of a replica group
+// and the winner of a conflict the same on every poll.
+type configurationBuilder struct {
+	opts      ConfigurationOptions
+	nodeNames []string
+	guests    map[uint64]*builtGuest
+	// weightedGroups are the replica groups with a weighted member, they balance between the services of their
+	// members instead of merging them.
+	weightedGroups map[string]bool
+	// order lists the guests of a node by VMID without copying them, it's reused between nodes.
+	order []int
+}
+
+// builtGuest is the configuration built for a guest, waiting to be merged.
+type builtGuest struct {
+	node string
+	// summary holds the fields of the service deciding between the copies of a migrating guest.
+	summary   proxmox.Service
+	group     string
+	weight    int
+	weightErr error
+	standby   *standby
+	fragment  *dynamic.Configuration
+	errors    []GuestError
+}
+
+func newConfigurationBuilder(opts ConfigurationOptions) *configurationBuilder {
+	return &configurationBuilder{opts: opts, guests: make(map[uint64]*builtGuest), weightedGroups: make(map[string]bool)}
+}
+
+// addNode builds the configuration of the guests of a node. The services aren't kept, the caller may reuse
+// the slice. A guest already added from another node is replaced when this copy is preferred, see
+// dedupeByVMID.
+func (b *configurationBuilder) addNode(nodeName string, services []proxmox.Service) {
+	b.nodeNames = append(b.nodeNames, nodeName)
+	b.order = b.order[:0]
+	for i := range services {
+		b.order = append(b.order, i)
+	}
+	sort.SliceStable(b.order, func(i, j int) bool { return services[b.order[i]].ID < services[b.order[j]].ID })
+	for _, i := range b.order {
+		b.addGuest(nodeName, services[i])
+	}
+}
+
+func (b *configurationBuilder) addGuest(nodeName string, service proxmox.Service) {
+	summary := proxmox.Service{ID: service.ID, Name: service.Name, Status: service.Status, IPs: service.IPs}
+	if current := b.guests[service.ID]; current != nil {
+		if !preferService(summary, current.summary) {
+			log.Printf("Skipping duplicate of guest %s (%d) on node %s, using the one on node %s", service.Name, service.ID, nodeName, current.node)
+			return
+		}
+		log.Printf("Skipping duplicate of guest %s (%d) on node %s, using the one on node %s", service.Name, service.ID, current.node, nodeName)
+	}
+
+	guest := &builtGuest{node: nodeName, summary: summary, group: service.Config[groupLabel], fragment: newConfiguration()}
+	if guest.group == "" {
+		// Members of a replica group share their names on purpose.
+		service.Config = namespaceLabels(service, nodeName, b.opts.Namespace)
+	} else {
+		if service.Config[weightLabel] != "" {
+			b.weightedGroups[guest.group] = true
+		}
+		guest.weight, guest.weightErr = memberWeight(service)
+	}
+	if primary := service.Config[failoverForLabel]; primary != "" {
+		guest.standby = &standby{primary: primary, service: standbyService(service)}
+	}
+	guest.errors = buildGuestConfiguration(guest.fragment, service, nodeName, b.opts)
+	b.guests[service.ID] = guest
+}
+
+// guestCounts returns the number of guests added for each node, without the duplicates replaced since.
+func (b *configurationBuilder) guestCounts() map[string]int {
+	counts := make(map[string]int, len(b.nodeNames))
+	for _, nodeName := range b.nodeNames {
+		counts[nodeName] = 0
+	}
+	for _, guest := range b.guests {
+		counts[guest.node]++
+	}
+	return counts
+}
+
+// build merges the configurations of the guests and applies the defaults of the options.
+func (b *configurationBuilder) build() (*dynamic.Configuration, []GuestError) {
+	opts := b.opts
+	config := newConfiguration()
+
+	guests := make([]*builtGuest, 0, len(b.guests))
+	for _, guest := range b.guests {
+		guests = append(guests, guest)
+	}
+	sort.Slice(guests, func(i, j int) bool {
+		if guests[i].node != guests[j].node {
+			return guests[i].node < guests[j].node
+		}
+		return guests[i].summary.ID < guests[j].summary.ID
+	})
+	nodeNames := append([]string(nil), b.nodeNames...)
+	sort.Strings(nodeNames)
+
+	var guestErrors []GuestError
+	var standbys []standby
+	// owners records which guest or replica group defined each element, to report conflicts between guests.
+	owners := make(map[string]string)
+	for _, guest := range guests {
+		service, nodeName, fragment := guest.summary, guest.node, guest.fragment
+		guestErrors = append(guestErrors, guest.errors...)
+		if guest.standby != nil {
+			standbys = append(standbys, *guest.standby)
+		}
+
+		// Each guest was built apart, it is merged now: the members of a replica group into the services of
+		// the group, other guests only where they don't conflict with the elements of another guest.
+		owner := fmt.Sprintf("VMID %d", service.ID)
+		if guest.group != "" {
+			owner = "group " + guest.group
+			if b.weightedGroups[guest.group] {
+				if guest.weightErr != nil {
+					guestErrors = append(guestErrors, GuestError{Node: nodeName, VMID: service.ID, Name: service.Name, Error: guest.weightErr.Error()})
+				}
+				weightServices(fragment.HTTP, fmt.Sprintf("%s-%d", sanitizeName(service.Name), service.ID), guest.weight)
+			}
+		}
+		for _, conflict := range resolveConflicts(config, fragment, owners, owner) {
+			log.Printf("WARNING: %s", conflict)
+			guestErrors = append(guestErrors, GuestError{Node: nodeName, VMID: service.ID, Name: service.Name, Error: conflict})
+		}
+		mergeGuestConfiguration(config, fragment)
+	}
+
+	opts.DecodeCache.flush()
+
+	if opts.ProxmoxUIHost != "" {
+		ui := buildProxmoxUIConfiguration(opts, nodeNames)
+		for _, conflict := range resolveConflicts(config, ui, owners, "the Proxmox web interface") {
+			log.Printf("WARNING: %s", conflict)
+		}
+		mergeGuestConfiguration(config, ui)
+	}
+
+	addFailoverServices(config.HTTP, standbys)
+
+	if len(opts.DefaultEntryPoints) > 0 {
+		for _, router := range config.HTTP.Routers {
+			if len(router.EntryPoints) == 0 {
+				router.EntryPoints = append([]string(nil), opts.DefaultEntryPoints...)
+			}
+		}
+	}
+	if opts.DefaultCertResolver != "" {
+		for _, router := range config.HTTP.Routers {
+			if router.TLS == nil {
+				router.TLS = &dynamic.RouterTLSConfig{}
+			}
+			if router.TLS.CertResolver == "" {
+				router.TLS.CertResolver = opts.DefaultCertResolver
+			}
+		}
+	}
+	if opts.DefaultTLSDomain != "" {
+		for _, router := range config.HTTP.Routers {
+			if router.TLS != nil && len(router.TLS.Domains) == 0 {
+				router.TLS.Domains = []types.Domain{{Main: opts.DefaultTLSDomain, SANs: append([]string(nil), opts.DefaultTLSSANs...)}}
+			}
+		}
+	}
+	if opts.HTTPSRedirect {
+		addRedirectRouters(config.HTTP, opts.HTTPSRedirectEntryPoint)
+	}
+	if len(opts.DefaultTCPMiddlewares) > 0 {
+		for _, router := range config.TCP.Routers {
+			if len(router.Middlewares) == 0 {
+				router.Middlewares = append([]string(nil), opts.DefaultTCPMiddlewares...)
+			}
+		}
+	}
+
+	if opts.DefaultHealthCheck != nil {
+		for _, service := range config.HTTP.Services {
+			if service.LoadBalancer != nil && service.LoadBalancer.HealthCheck == nil && len(service.LoadBalancer.Servers) > 0 {
+				healthCheck := *opts.DefaultHealthCheck
+				// gRPC backends have no HTTP path to check, they're checked with the gRPC health protocol.
+				if healthCheck.Mode == "" && isH2CLoadBalancer(service.LoadBalancer) {
+					healthCheck.Mode = "grpc"
+				}
+				service.LoadBalancer.HealthCheck = &healthCheck
+			}
+		}
+	}
+	if opts.DefaultSticky != nil {
+		for _, service := range config.HTTP.Services {
+			if service.LoadBalancer != nil && service.LoadBalancer.Sticky == nil && len(service.LoadBalancer.Servers) > 1 {
+				sticky := *opts.DefaultSticky
+				service.LoadBalancer.Sticky = &sticky
+			}
+		}
+	}
+
+	adaptToTraefikVersion(config, opts.TraefikVersion)
+
+	if !opts.generates("http") {
+		config.HTTP = nil
+	}
+	if !opts.generates("tcp") {
+		config.TCP = nil
+	}
+	if !opts.generates("udp") {
+		config.UDP = nil
+	}
+
+	sort.Slice(guestErrors, func(i, j int) bool {
+		if guestErrors[i].Node != guestErrors[j].Node {
+			return guestErrors[i].Node < guestErrors[j].Node
+		}
+		return guestErrors[i].VMID < guestErrors[j].VMID
+	})
+	return config, guestErrors
+}
+
+// isH2CLoadBalancer reports whether every server of a load balancer speaks h2c.
+func isH2CLoadBalancer(lb *dynamic.ServersLoadBalancer) bool {
+	for _, server := range lb.Servers {
+		if !strings.HasPrefix(server.URL, "h2c://") {
+			return false
+		}
+	}
+	return true
+}
+
+// httpsRedirectMiddleware is the redirect scheme middleware shared by the redirect routers.
+const httpsRedirectMiddleware = "redirect-to-https"
+
+// addRedirectRouters adds a <router>-redirect router with the same rule on the entry point, web by default,
+// redirecting to HTTPS for every router with TLS. Routers already listening on the entry point are skipped.
+func addRedirectRouters(httpConfig *dynamic.HTTPConfiguration, entryPoint string) {
+	if entryPoint == "" {
+		entryPoint = "web"
+	}
+
+	var names []string
+	for name, router := range httpConfig.Routers {
+		if router.TLS == nil || router.Rule == "" {
+			continue
+		}
+		listening := false
+		for _, ep := range router.EntryPoints {
+			if ep == entryPoint {
+				listening = true
+			}
+		}
+		if !listening {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	if _, ok := httpConfig.Middlewares[httpsRedirectMiddleware]; !ok {
+		httpConfig.Middlewares[httpsRedirectMiddleware] = &dynamic.Middleware{
+			RedirectScheme: &dynamic.RedirectScheme{Scheme: "https", Permanent: true},
+		}
+	}
+	for _, name := range names {
+		redirectName := name + "-redirect"
+		if _, ok := httpConfig.Routers[redirectName]; ok {
+			continue
+		}
+		router := httpConfig.Routers[name]
+		redirect := &dynamic.Router{
+			EntryPoints: []string{entryPoint},
+			Middlewares: []string{httpsRedirectMiddleware},
+			Service:     router.Service,
+			Rule:        router.Rule,
+			RuleSyntax:  router.RuleSyntax,
+		}
+		if router.Priority != nil {
+			priority := *router.Priority
+			redirect.Priority = &priority
+		}
+		httpConfig.Routers[redirectName] = redirect
+	}
+}
+
+// standby is a guest labelled traefik.proxmox.failoverFor, whose HTTP service takes over the traffic of
+// the primary service when it is down.
+type standby struct {
+	primary string
+	service string
+}
+
+// standbyService returns the HTTP service of a standby guest: the first one defined in its labels, else its default one.
+func standbyService(service proxmox.Service) string {
+	for _, name := range getDefinedElements(service.Config, "http", "services") {
+		if !isServiceTypeLabelName(service.Config, name) {
+			return name
+		}
+	}
+	return serviceID(service.Name, service.ID, service.Config)
+}
+
+// isServiceTypeLabelName reports whether the HTTP service name is configured by the labels of the serviceTypes.
+func isServiceTypeLabelName(labels map[string]string, name string) bool {
+	prefix := "traefik.http.services." + name + "."
+	for key := range labels {
+		if strings.HasPrefix(key, prefix) && isServiceTypeLabel(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// addFailoverServices adds a <primary>-failover service falling back to the standby of each primary service,
+// and points the routers of the primary service at it. The first standby of a primary wins.
+func addFailoverServices(httpConfig *dynamic.HTTPConfiguration, standbys []standby) {
+	for _, s := range standbys {
+		name := s.primary + "-failover"
+		if _, ok := httpConfig.Services[name]; ok {
+			log.Printf("Warning: service %s has several standbys, ignoring %s", s.primary, s.service)
+			continue
+		}
+		if httpConfig.Services[s.primary] == nil {
+			log.Printf("Warning: ignoring standby %s, its primary service %s doesn't exist", s.service, s.primary)
+			continue
+		}
+		if httpConfig.Services[s.service] == nil {
+			log.Printf("Warning: ignoring standby %s of %s, the service doesn't exist", s.service, s.primary)
+			continue
+		}
+
+		httpConfig.Services[name] = &dynamic.Service{Failover: &dynamic.Failover{Service: s.primary, Fallback: s.service}}
+		for _, router := range httpConfig.Routers {
+			if router.Service == s.primary {
+				router.Service = name
+			}
+		}
+	}
+}
+
+// memberWeight returns the weight of a member of a weighted replica group: its traefik.proxmox.weight label,
+// 1 without one and 0 while the guest is stopped or draining.
+func memberWeight(service proxmox.Service) (int, error) {
+	if isServiceDown(service) || service.Draining {
+		return 0, nil
+	}
+	value := service.Config[weightLabel]
+	if value == "" {
+		return 1, nil
+	}
+	weight, err := strconv.Atoi(value)
+	if err != nil || weight < 0 {
+		return 1, fmt.Errorf("invalid %s %q, expected a positive integer", weightLabel, value)
+	}
+	return weight, nil
+}
+
+// weightServices renames the HTTP load balancers of a member of a weighted replica group to <service>-<member>,
+// and replaces them with a weighted round robin service sending the member its share of the traffic.
+func weightServices(httpConfig *dynamic.HTTPConfiguration, member string, weight int) {
+	var names []string
+	for name, service := range httpConfig.Services {
+		if service.LoadBalancer != nil {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		memberService := name + "-" + member
+		httpConfig.Services[memberService] = httpConfig.Services[name]
+		memberWeight := weight
+		httpConfig.Services[name] = &dynamic.Service{Weighted: &dynamic.WeightedRoundRobin{
+			Services: []dynamic.WRRService{{Name: memberService, Weight: &memberWeight}},
+		}}
+	}
+}
+
+// resolveConflicts removes the elements of a guest's configuration that another guest or replica group already
+// defined differently, so the first definition wins whatever the order of the API responses, and describes them.
+// Identical definitions, e.g. a middleware set for every guest of a pool, are not conflicts.
+func resolveConflicts(config, fragment *dynamic.Configuration, owners map[string]string, owner string) []string {
+	var conflicts []string
+	conflict := func(kind, name string, exists bool, existing, element interface{}) bool {
+		key := kind + "/" + name
+		previous, ok := owners[key]
+		if !exists || !ok {
+			owners[key] = owner
+			return false
+		}
+		if previous == owner || reflect.DeepEqual(existing, element) {
+			return false
+		}
+		conflicts = append(conflicts, fmt.Sprintf("%s %s is defined by %s and %s, keeping the definition of %s", kind, name, previous, owner, previous))
+		return true
+	}
+
+	for name, element := range fragment.HTTP.Routers {
+		existing, ok := config.HTTP.Routers[name]
+		if conflict("HTTP router", name, ok, existing, element) {
+			delete(fragment.HTTP.Routers, name)
+		}
+	}
+	for name, element := range fragment.HTTP.Services {
+		existing, ok := config.HTTP.Services[name]
+		if conflict("HTTP service", name, ok, existing, element) {
+			delete(fragment.HTTP.Services, name)
+		}
+	}
+	for name, element := range fragment.HTTP.Middlewares {
+		existing, ok := config.HTTP.Middlewares[name]
+		if conflict("HTTP middleware", name, ok, existing, element) {
+			delete(fragment.HTTP.Middlewares, name)
+		}
+	}
+	for name, element := range fragment.HTTP.ServersTransports {
+		existing, ok := config.HTTP.ServersTransports[name]
+		if conflict("HTTP serversTransport", name, ok, existing, element) {
+			delete(fragment.HTTP.ServersTransports, name)
+		}
+	}
+	for name, element := range fragment.TCP.Routers {
+		existing, ok := config.TCP.Routers[name]
+		if conflict("TCP router", name, ok, existing, element) {
+			delete(fragment.TCP.Routers, name)
+		}
+	}
+	for name, element := range fragment.TCP.Services {
+		existing, ok := config.TCP.Services[name]
+		if conflict("TCP service", name, ok, existing, element) {
+			delete(fragment.TCP.Services, name)
+		}
+	}
+	for name, element := range fragment.TCP.Middlewares {
+		existing, ok := config.TCP.Middlewares[name]
+		if conflict("TCP middleware", name, ok, existing, element) {
+			delete(fragment.TCP.Middlewares, name)
+		}
+	}
+	for name, element := range fragment.TCP.ServersTransports {
+		existing, ok := config.TCP.ServersTransports[name]
+		if conflict("TCP serversTransport", name, ok, existing, element) {
+			delete(fragment.TCP.ServersTransports, name)
+		}
+	}
+	for name, element := range fragment.UDP.Routers {
+		existing, ok := config.UDP.Routers[name]
+		if conflict("UDP router", name, ok, existing, element) {
+			delete(fragment.UDP.Routers, name)
+		}
+	}
+	for name, element := range fragment.UDP.Services {
+		existing, ok := config.UDP.Services[name]
+		if conflict("UDP service", name, ok, existing, element) {
+			delete(fragment.UDP.Services, name)
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// mergeGuestConfiguration adds the configuration of a guest. Load balancers with the same name, i.e. of
+// the members of a replica group, are merged into one with the servers of every member; for everything
+// else, such as routers, the first member wins.
+func mergeGuestConfiguration(config, fragment *dynamic.Configuration) {
+	for name, router := range fragment.HTTP.Routers {
+		if _, ok := config.HTTP.Routers[name]; !ok {
+			config.HTTP.Routers[name] = router
+		}
+	}
+	for name, service := range fragment.HTTP.Services {
+		existing, ok := config.HTTP.Services[name]
+		if !ok {
+			config.HTTP.Services[name] = service
+			continue
+		}
+		if existing.LoadBalancer != nil && service.LoadBalancer != nil {
+			servers := append(existing.LoadBalancer.Servers, service.LoadBalancer.Servers...)
+			sort.SliceStable(servers, func(i, j int) bool { return servers[i].URL < servers[j].URL })
+			existing.LoadBalancer.Servers = servers
+		}
+		if existing.Weighted != nil && service.Weighted != nil {
+			services := append(existing.Weighted.Services, service.Weighted.Services...)
+			sort.SliceStable(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+			existing.Weighted.Services = services
+		}
+	}
+	for name, middleware := range fragment.HTTP.Middlewares {
+		if _, ok := config.HTTP.Middlewares[name]; !ok {
+			config.HTTP.Middlewares[name] = middleware
+		}
+	}
+	for name, transport := range fragment.HTTP.ServersTransports {
+		if config.HTTP.ServersTransports == nil {
+			config.HTTP.ServersTransports = make(map[string]*dynamic.ServersTransport)
+		}
+		if _, ok := config.HTTP.ServersTransports[name]; !ok {
+			config.HTTP.ServersTransports[name] = transport
+		}
+	}
+
+	for name, router := range fragment.TCP.Routers {
+		if _, ok := config.TCP.Routers[name]; !ok {
+			config.TCP.Routers[name] = router
+		}
+	}
+	for name, service := range fragment.TCP.Services {
+		existing, ok := config.TCP.Services[name]
+		if !ok {
+			config.TCP.Services[name] = service
+			continue
+		}
+		if existing.LoadBalancer != nil && service.LoadBalancer != nil {
+			servers := append(existing.LoadBalancer.Servers, service.LoadBalancer.Servers...)
+			sort.SliceStable(servers, func(i, j int) bool { return servers[i].Address < servers[j].Address })
+			existing.LoadBalancer.Servers = servers
+		}
+	}
+	for name, middleware := range fragment.TCP.Middlewares {
+		if config.TCP.Middlewares == nil {
+			config.TCP.Middlewares = make(map[string]*dynamic.TCPMiddleware)
+		}
+		if _, ok := config.TCP.Middlewares[name]; !ok {
+			config.TCP.Middlewares[name] = middleware
+		}
+	}
+	for name, transport := range fragment.TCP.ServersTransports {
+		if config.TCP.ServersTransports == nil {
+			config.TCP.ServersTransports = make(map[string]*dynamic.TCPServersTransport)
+		}
+		if _, ok := config.TCP.ServersTransports[name]; !ok {
+			config.TCP.ServersTransports[name] = transport
+		}
+	}
+
+	for name, router := range fragment.UDP.Routers {
+		if _, ok := config.UDP.Routers[name]; !ok {
+			config.UDP.Routers[name] = router
+		}
+	}
+	for name, service := range fragment.UDP.Services {
+		existing, ok := config.UDP.Services[name]
+		if !ok {
+			config.UDP.Services[name] = service
+			continue
+		}
+		if existing.LoadBalancer != nil && service.LoadBalancer != nil {
+			servers := append(existing.LoadBalancer.Servers, service.LoadBalancer.Servers...)
+			sort.SliceStable(servers, func(i, j int) bool { return servers[i].Address < servers[j].Address })
+			existing.LoadBalancer.Servers = servers
+		}
+	}
+
+	if fragment.TLS == nil {
+		return
+	}
+	if config.TLS == nil {
+		config.TLS = &dynamic.TLSConfiguration{}
+	}
+	config.TLS.Certificates = append(config.TLS.Certificates, fragment.TLS.Certificates...)
+	for name, options := range fragment.TLS.Options {
+		if config.TLS.Options == nil {
+			config.TLS.Options = make(map[string]tls.Options)
+		}
+		if _, ok := config.TLS.Options[name]; !ok {
+			config.TLS.Options[name] = options
+		}
+	}
+	for name, store := range fragment.TLS.Stores {
+		if config.TLS.Stores == nil {
+			config.TLS.Stores = make(map[string]tls.Store)
+		}
+		if _, ok := config.TLS.Stores[name]; !ok {
+			config.TLS.Stores[name] = store
+		}
+	}
+}
+
+// newConfiguration returns an empty configuration with the maps the builders fill in.
+func newConfiguration() *dynamic.Configuration {
+	return &dynamic.Configuration{
 		HTTP: &dynamic.HTTPConfiguration{
 			Routers:     make(map[string]*dynamic.Router),
 			Services:    make(map[string]*dynamic.Service),
@@ -27,31 +750,605 @@ func generateConfiguration(servicesMap map[string][]internal.Service) *dynamic.C
 			Services: make(map[string]*dynamic.UDPService),
 		},
 	}
+}
 
-	for nodeName, services := range servicesMap {
-		for _, service := range services {
-			log.Printf("Processing service %s (ID: %d) on node %s", service.Name, service.ID, nodeName)
+// buildGuestConfiguration adds the configuration of a guest and returns its configuration errors.
+func buildGuestConfiguration(config *dynamic.Configuration, service proxmox.Service, nodeName string, opts ConfigurationOptions) []GuestError {
+	var guestErrors []GuestError
+	log.Printf("Processing service %s (ID: %d) on node %s", service.Name, service.ID, nodeName)
 
-			// Populate all user-defined configuration from labels
-			err := parser.Decode(service.Config, config, "traefik", "traefik.http", "traefik.tcp", "traefik.udp")
-			if err != nil {
-				log.Printf("ERROR: Could not decode labels for service %s: %v", service.Name, err)
-				continue
+	service.Config = expandLabelTemplates(service, nodeName)
+	if len(opts.Protocols) > 0 {
+		service.Config, _ = splitLabels(service.Config, func(key string) bool {
+			parts := strings.SplitN(strings.ToLower(key), ".", 3)
+			return len(parts) == 3 && parts[0] == "traefik" && (parts[1] == "http" || parts[1] == "tcp" || parts[1] == "udp") && !opts.generates(parts[1])
+		})
+	}
+
+	// Populate all user-defined configuration from labels
+	if err := opts.DecodeCache.decode(config, service.Config); err != nil {
+		log.Printf("ERROR: Could not decode labels for service %s: %v", service.Name, err)
+		guestErrors = append(guestErrors, GuestError{Node: nodeName, VMID: service.ID, Name: service.Name, Error: err.Error()})
+		if opts.Strict {
+			addErrorRouter(config.HTTP, service)
+		}
+		return guestErrors
+	}
+
+	// Members of a replica group in maintenance are drained, so the other members keep serving the group.
+	maintenance := service.Config[maintenanceLabel] == "true"
+	if maintenance && service.Config[groupLabel] != "" {
+		service.Draining = true
+		maintenance = false
+	}
+
+	// Build defaults and enrich configurations for each protocol.
+	if opts.generates("http") {
+		buildHTTPConfiguration(config.HTTP, service, nodeName, opts)
+	}
+	if opts.generates("tcp") {
+		buildTCPConfiguration(config.TCP, service, nodeName, opts)
+	}
+	if opts.generates("udp") {
+		buildUDPConfiguration(config.UDP, service, nodeName, opts)
+	}
+	if len(opts.PortEntryPoints) > 0 {
+		inferEntryPoints(config, opts.PortEntryPoints)
+	}
+	addPortRangeEntryPoints(config.TCP, serviceID(service.Name, service.ID, service.Config))
+	if maintenance {
+		addMaintenanceRouters(config.HTTP, service, opts.MaintenanceURL)
+	}
+	if len(opts.DefaultMiddlewares) > 0 && service.Config[defaultMiddlewaresLabel] != "false" {
+		for _, router := range config.HTTP.Routers {
+			router.Middlewares = appendMissing(router.Middlewares, opts.DefaultMiddlewares)
+		}
+	}
+
+	if err := decodeTLSLabels(config, service.Config); err != nil {
+		log.Printf("ERROR: Could not decode TLS labels for service %s: %v", service.Name, err)
+		guestErrors = append(guestErrors, GuestError{Node: nodeName, VMID: service.ID, Name: service.Name, Error: err.Error()})
+	}
+	if err := decodeServersTransportLabels(config, service.Config); err != nil {
+		log.Printf("ERROR: Could not decode servers transport labels for service %s: %v", service.Name, err)
+		guestErrors = append(guestErrors, GuestError{Node: nodeName, VMID: service.ID, Name: service.Name, Error: err.Error()})
+	}
+	if len(service.Certificates) > 0 {
+		if config.TLS == nil {
+			config.TLS = &dynamic.TLSConfiguration{}
+		}
+		config.TLS.Certificates = append(config.TLS.Certificates, service.Certificates...)
+	}
+
+	if err := mergeConfigDocument(config, service); err != nil {
+		log.Printf("ERROR: Could not decode %s of service %s: %v", proxmox.ConfigDocumentLabel, service.Name, err)
+		guestErrors = append(guestErrors, GuestError{
+			Node:  nodeName,
+			VMID:  service.ID,
+			Name:  service.Name,
+			Error: fmt.Sprintf("invalid %s: %v", proxmox.ConfigDocumentLabel, err),
+		})
+	}
+	return guestErrors
+}
+
+// decodeGuestLabels decodes the labels of a guest into config.
+func decodeGuestLabels(config *dynamic.Configuration, labels map[string]string) error {
+	labels, serviceTypeLabels := splitServiceTypeLabels(labels)
+	labels, serverLabels := splitLabels(labels, isIndexedServerLabel)
+	labels, _ = splitLabels(labels, isServerPathLabel)
+	if err := parser.Decode(labels, config, "traefik", "traefik.http", "traefik.tcp", "traefik.udp"); err != nil {
+		return err
+	}
+	if err := decodeServiceTypeLabels(config, serviceTypeLabels); err != nil {
+		return err
+	}
+	return decodeIndexedServerLabels(config, serverLabels)
+}
+
+// addErrorRouter routes the default host of a guest with broken labels to a service without servers, whose
+// 503 Service Unavailable an errors middleware rewrites to 500 Internal Server Error, so a broken guest can be
+// told apart from one in maintenance. The router, service and middleware are named error-<name>-<vmid>.
+// Traefik v2 has no status rewrites and answers 503.
+func addErrorRouter(httpConfig *dynamic.HTTPConfiguration, service proxmox.Service) {
+	name := fmt.Sprintf("error-%s-%d", sanitizeName(service.Name), service.ID)
+	priority := 1
+	httpConfig.Routers[name] = &dynamic.Router{
+		Rule:        fmt.Sprintf("Host(`%s`)", defaultHost(service)),
+		Service:     name,
+		Middlewares: []string{name},
+		Priority:    &priority,
+	}
+	httpConfig.Services[name] = &dynamic.Service{
+		LoadBalancer: &dynamic.ServersLoadBalancer{Servers: []dynamic.Server{}},
+	}
+	httpConfig.Middlewares[name] = &dynamic.Middleware{
+		Errors: &dynamic.ErrorPage{
+			Status:         []string{"503"},
+			StatusRewrites: map[string]int{"503": 500},
+			Service:        name,
+			Query:          "/",
+		},
+	}
+}
+
+// portRangeEntryPointPrefix prefixes the port in the name of the entry point generated for the routers of a
+// port range, e.g. tcp-25565.
+const portRangeEntryPointPrefix = "tcp-"
+
+// addPortRangeEntryPoints sets the entry point tcp-<port> on the routers of a port range (traefik.tcp.ports or
+// publishAll) that match any connection and have no entry point, neither from a label nor from portEntryPoints:
+// on a shared entry point their HostSNI(`*`) rules would collide, so each port gets its own.
+func addPortRangeEntryPoints(tcpConfig *dynamic.TCPConfiguration, defaultID string) {
+	prefix := defaultID + "-"
+	for name, router := range tcpConfig.Routers {
+		port := strings.TrimPrefix(name, prefix)
+		if port == name || len(router.EntryPoints) > 0 || router.Rule != "HostSNI(`*`)" || router.Service != name {
+			continue
+		}
+		service := tcpConfig.Services[name]
+		if service == nil || service.LoadBalancer == nil || len(service.LoadBalancer.Servers) == 0 {
+			continue
+		}
+		if _, serverPort, err := net.SplitHostPort(service.LoadBalancer.Servers[0].Address); err != nil || serverPort != port {
+			continue
+		}
+		router.EntryPoints = []string{portRangeEntryPointPrefix + port}
+	}
+}
+
+// inferEntryPoints sets the entry point mapped to the port of the first server of their service on the
+// HTTP and TCP routers without entry points.
+func inferEntryPoints(config *dynamic.Configuration, portEntryPoints map[string]string) {
+	for _, router := range config.HTTP.Routers {
+		service := config.HTTP.Services[router.Service]
+		if len(router.EntryPoints) > 0 || service == nil || service.LoadBalancer == nil || len(service.LoadBalancer.Servers) == 0 {
+			continue
+		}
+		u, err := url.Parse(service.LoadBalancer.Servers[0].URL)
+		if err != nil {
+			continue
+		}
+		if entryPoint := portEntryPoints[u.Port()]; entryPoint != "" {
+			router.EntryPoints = []string{entryPoint}
+		}
+	}
+	for _, router := range config.TCP.Routers {
+		service := config.TCP.Services[router.Service]
+		if len(router.EntryPoints) > 0 || service == nil || service.LoadBalancer == nil || len(service.LoadBalancer.Servers) == 0 {
+			continue
+		}
+		_, port, err := net.SplitHostPort(service.LoadBalancer.Servers[0].Address)
+		if err != nil {
+			continue
+		}
+		if entryPoint := portEntryPoints[port]; entryPoint != "" {
+			router.EntryPoints = []string{entryPoint}
+		}
+	}
+}
+
+// appendMissing appends the values that are not in the list yet.
+func appendMissing(list, values []string) []string {
+	for _, value := range values {
+		found := false
+		for _, existing := range list {
+			if existing == value {
+				found = true
+				break
 			}
+		}
+		if !found {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+// maintenancePageService is the service serving the maintenance page shared by the guests in maintenance.
+const maintenancePageService = "maintenance-page"
 
-			// Build defaults and enrich configurations for each protocol.
-			buildHTTPConfiguration(config.HTTP, service, nodeName)
-			buildTCPConfiguration(config.TCP, service, nodeName)
-			buildUDPConfiguration(config.UDP, service, nodeName)
+// addMaintenanceRouters points the HTTP routers of a guest in maintenance at a service without servers, named
+// maintenance-<name>-<vmid>, which Traefik answers with 503 Service Unavailable. With a maintenance URL, an errors
+// middleware of the same name replaces the response with the maintenance page.
+func addMaintenanceRouters(httpConfig *dynamic.HTTPConfiguration, service proxmox.Service, maintenanceURL string) {
+	name := fmt.Sprintf("maintenance-%s-%d", sanitizeName(service.Name), service.ID)
+	httpConfig.Services[name] = &dynamic.Service{
+		LoadBalancer: &dynamic.ServersLoadBalancer{Servers: []dynamic.Server{}},
+	}
+	if maintenanceURL != "" {
+		httpConfig.Services[maintenancePageService] = &dynamic.Service{
+			LoadBalancer: &dynamic.ServersLoadBalancer{Servers: []dynamic.Server{{URL: maintenanceURL}}},
+		}
+		httpConfig.Middlewares[name] = &dynamic.Middleware{
+			Errors: &dynamic.ErrorPage{Status: []string{"503"}, Service: maintenancePageService, Query: "/"},
 		}
 	}
 
-	return config
+	routers := getDefinedElements(service.Config, "http", "routers")
+	if len(routers) == 0 {
+		routers = []string{serviceID(service.Name, service.ID, service.Config)}
+	}
+	for _, routerName := range routers {
+		router := httpConfig.Routers[routerName]
+		if router == nil {
+			continue
+		}
+		log.Printf("Service %s is in maintenance, routing %s to %s", service.Name, routerName, name)
+		router.Service = name
+		if maintenanceURL != "" {
+			router.Middlewares = append([]string{name}, router.Middlewares...)
+		}
+	}
+}
+
+// tlsLabels is the part of the configuration read from the traefik.tls.* labels. Traefik's own label
+// providers don't read TLS options, so dynamic.TLSConfiguration hides them from the label parser.
+type tlsLabels struct {
+	TLS *tlsLabelsConfiguration `json:"tls,omitempty"`
+}
+
+type tlsLabelsConfiguration struct {
+	Options map[string]tls.Options `json:"options,omitempty"`
+	Stores  map[string]tls.Store   `json:"stores,omitempty"`
+}
+
+// decodeTLSLabels adds the TLS options and stores declared with traefik.tls.options.<name>.* and
+// traefik.tls.stores.<name>.* labels, which routers reference with tls.options=<name>.
+func decodeTLSLabels(config *dynamic.Configuration, labels map[string]string) error {
+	decoded := &tlsLabels{}
+	if err := parser.Decode(labels, decoded, "traefik", "traefik.tls"); err != nil {
+		return err
+	}
+	if decoded.TLS == nil {
+		return nil
+	}
+
+	if config.TLS == nil {
+		config.TLS = &dynamic.TLSConfiguration{}
+	}
+	for name, options := range decoded.TLS.Options {
+		if config.TLS.Options == nil {
+			config.TLS.Options = make(map[string]tls.Options)
+		}
+		config.TLS.Options[name] = options
+	}
+	for name, store := range decoded.TLS.Stores {
+		if config.TLS.Stores == nil {
+			config.TLS.Stores = make(map[string]tls.Store)
+		}
+		config.TLS.Stores[name] = store
+	}
+	return nil
+}
+
+// serviceTypes are the HTTP service types dynamic.Service hides from the label parser, and which
+// are read from traefik.http.services.<name>.<type>.* labels on their own.
+var serviceTypes = []string{"mirroring", "failover"}
+
+// httpServiceLabels is the part of the configuration read from the labels of the serviceTypes.
+type httpServiceLabels struct {
+	HTTP *httpServiceLabelsConfiguration `json:"http,omitempty"`
+}
+
+type httpServiceLabelsConfiguration struct {
+	Services map[string]*httpServiceTypes `json:"services,omitempty"`
+}
+
+type httpServiceTypes struct {
+	Mirroring *dynamic.Mirroring `json:"mirroring,omitempty"`
+	Failover  *dynamic.Failover  `json:"failover,omitempty"`
+}
+
+// splitServiceTypeLabels separates the labels of the serviceTypes from the other labels.
+func splitServiceTypeLabels(labels map[string]string) (map[string]string, map[string]string) {
+	return splitLabels(labels, isServiceTypeLabel)
+}
+
+// splitLabels separates the labels whose key matches from the other labels.
+func splitLabels(labels map[string]string, match func(key string) bool) (map[string]string, map[string]string) {
+	var matched map[string]string
+	for key := range labels {
+		if match(key) {
+			if matched == nil {
+				matched = make(map[string]string)
+			}
+			matched[key] = labels[key]
+		}
+	}
+	if matched == nil {
+		return labels, nil
+	}
+
+	rest := make(map[string]string, len(labels)-len(matched))
+	for key, value := range labels {
+		if _, ok := matched[key]; !ok {
+			rest[key] = value
+		}
+	}
+	return rest, matched
+}
+
+// indexedServerLabel matches the labels of the servers[n] form of load balancer servers, e.g.
+// traefik.http.services.web.loadbalancer.servers[1].url, which the label parser only reads as server.
+var indexedServerLabel = regexp.MustCompile(`(?i)^traefik\.(http|tcp|udp)\.services\.([^.]+)\.loadbalancer\.servers\[(\d+)\]\.(.+)$`)
+
+func isIndexedServerLabel(key string) bool {
+	return indexedServerLabel.MatchString(key)
+}
+
+// serverPathLabel matches the path label of the server of an HTTP service, e.g.
+// traefik.http.services.web.loadbalancer.server.path, which Traefik's server has no field for and the
+// builder reads itself.
+var serverPathLabel = regexp.MustCompile(`(?i)^traefik\.http\.services\.([^.]+)\.loadbalancer\.server\.path$`)
+
+func isServerPathLabel(key string) bool {
+	return serverPathLabel.MatchString(key)
+}
+
+// serverPath returns the path label of the servers of an HTTP service.
+func serverPath(labels map[string]string, serviceName string) string {
+	for key, value := range labels {
+		if m := serverPathLabel.FindStringSubmatch(key); m != nil && m[1] == serviceName {
+			return value
+		}
+	}
+	return ""
+}
+
+// indexedServer is a load balancer server declared with servers[n] labels.
+type indexedServer struct {
+	proto   string
+	service string
+	index   int
+	labels  map[string]string
+}
+
+// decodeIndexedServerLabels adds a server per index of the servers[n] labels to the load balancers, in index
+// order and after the server declared with the singular server labels.
+func decodeIndexedServerLabels(config *dynamic.Configuration, labels map[string]string) error {
+	servers := make(map[string]*indexedServer)
+	for key, value := range labels {
+		m := indexedServerLabel.FindStringSubmatch(key)
+		if m == nil {
+			continue
+		}
+		proto := strings.ToLower(m[1])
+		id := proto + "/" + m[2] + "/" + m[3]
+		server := servers[id]
+		if server == nil {
+			index, _ := strconv.Atoi(m[3])
+			server = &indexedServer{proto: proto, service: m[2], index: index, labels: make(map[string]string)}
+			servers[id] = server
+		}
+		server.labels[fmt.Sprintf("traefik.%s.services.%s.loadbalancer.server.%s", proto, m[2], m[4])] = value
+	}
+
+	ordered := make([]*indexedServer, 0, len(servers))
+	for _, server := range servers {
+		ordered = append(ordered, server)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+		if a.proto != b.proto {
+			return a.proto < b.proto
+		}
+		if a.service != b.service {
+			return a.service < b.service
+		}
+		return a.index < b.index
+	})
+
+	for _, server := range ordered {
+		decoded := newConfiguration()
+		if err := parser.Decode(server.labels, decoded, "traefik", "traefik."+server.proto); err != nil {
+			return err
+		}
+		switch server.proto {
+		case "http":
+			service := config.HTTP.Services[server.service]
+			if service == nil {
+				service = &dynamic.Service{}
+				config.HTTP.Services[server.service] = service
+			}
+			if service.LoadBalancer == nil {
+				service.LoadBalancer = &dynamic.ServersLoadBalancer{}
+			}
+			if decodedService := decoded.HTTP.Services[server.service]; decodedService != nil && decodedService.LoadBalancer != nil {
+				service.LoadBalancer.Servers = append(service.LoadBalancer.Servers, decodedService.LoadBalancer.Servers...)
+			}
+		case "tcp":
+			service := config.TCP.Services[server.service]
+			if service == nil {
+				service = &dynamic.TCPService{}
+				config.TCP.Services[server.service] = service
+			}
+			if service.LoadBalancer == nil {
+				service.LoadBalancer = &dynamic.TCPServersLoadBalancer{}
+			}
+			if decodedService := decoded.TCP.Services[server.service]; decodedService != nil && decodedService.LoadBalancer != nil {
+				service.LoadBalancer.Servers = append(service.LoadBalancer.Servers, decodedService.LoadBalancer.Servers...)
+			}
+		case "udp":
+			service := config.UDP.Services[server.service]
+			if service == nil {
+				service = &dynamic.UDPService{}
+				config.UDP.Services[server.service] = service
+			}
+			if service.LoadBalancer == nil {
+				service.LoadBalancer = &dynamic.UDPServersLoadBalancer{}
+			}
+			if decodedService := decoded.UDP.Services[server.service]; decodedService != nil && decodedService.LoadBalancer != nil {
+				service.LoadBalancer.Servers = append(service.LoadBalancer.Servers, decodedService.LoadBalancer.Servers...)
+			}
+		}
+	}
+	return nil
+}
+
+// isServiceTypeLabel reports whether a label configures one of the serviceTypes, e.g.
+// traefik.http.services.shadow.mirroring.service.
+func isServiceTypeLabel(key string) bool {
+	const prefix = "traefik.http.services."
+	if len(key) <= len(prefix) || !strings.EqualFold(key[:len(prefix)], prefix) {
+		return false
+	}
+	parts := strings.SplitN(key[len(prefix):], ".", 3)
+	if len(parts) < 3 {
+		return false
+	}
+	for _, serviceType := range serviceTypes {
+		if strings.EqualFold(parts[1], serviceType) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeServiceTypeLabels adds the HTTP services of the serviceTypes declared in labels.
+func decodeServiceTypeLabels(config *dynamic.Configuration, labels map[string]string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+	decoded := &httpServiceLabels{}
+	if err := parser.Decode(labels, decoded, "traefik", "traefik.http.services"); err != nil {
+		return err
+	}
+	if decoded.HTTP == nil {
+		return nil
+	}
+
+	for name, types := range decoded.HTTP.Services {
+		configService := config.HTTP.Services[name]
+		if configService == nil {
+			configService = &dynamic.Service{}
+			config.HTTP.Services[name] = configService
+		}
+		if types.Mirroring != nil {
+			configService.Mirroring = types.Mirroring
+		}
+		if types.Failover != nil {
+			configService.Failover = types.Failover
+		}
+	}
+	return nil
+}
+
+// serversTransportLabels is the part of the configuration read from the traefik.tcp.serverstransports.* labels,
+// which dynamic.TCPConfiguration hides from the label parser as well.
+type serversTransportLabels struct {
+	TCP *tcpServersTransportLabels `json:"tcp,omitempty"`
+}
+
+type tcpServersTransportLabels struct {
+	ServersTransports map[string]*dynamic.TCPServersTransport `json:"serversTransports,omitempty"`
+}
+
+// decodeServersTransportLabels adds the TCP servers transports declared with
+// traefik.tcp.serverstransports.<name>.* labels, which services reference with loadbalancer.serverstransport=<name>.
+func decodeServersTransportLabels(config *dynamic.Configuration, labels map[string]string) error {
+	decoded := &serversTransportLabels{}
+	if err := parser.Decode(labels, decoded, "traefik", "traefik.tcp.serverstransports"); err != nil {
+		return err
+	}
+	if decoded.TCP == nil {
+		return nil
+	}
+
+	for name, transport := range decoded.TCP.ServersTransports {
+		if config.TCP.ServersTransports == nil {
+			config.TCP.ServersTransports = make(map[string]*dynamic.TCPServersTransport)
+		}
+		config.TCP.ServersTransports[name] = transport
+	}
+	return nil
+}
+
+// mergeConfigDocument merges the JSON document of the traefik.config label as is: its elements
+// replace the ones with the same name and are not completed with defaults.
+func mergeConfigDocument(config *dynamic.Configuration, service proxmox.Service) error {
+	document := service.Config[proxmox.ConfigDocumentLabel]
+	if document == "" {
+		return nil
+	}
+
+	var fragment dynamic.Configuration
+	if err := json.Unmarshal([]byte(document), &fragment); err != nil {
+		return err
+	}
+
+	if fragment.HTTP != nil {
+		for name, router := range fragment.HTTP.Routers {
+			config.HTTP.Routers[name] = router
+		}
+		for name, configService := range fragment.HTTP.Services {
+			config.HTTP.Services[name] = configService
+		}
+		for name, middleware := range fragment.HTTP.Middlewares {
+			config.HTTP.Middlewares[name] = middleware
+		}
+		for name, transport := range fragment.HTTP.ServersTransports {
+			if config.HTTP.ServersTransports == nil {
+				config.HTTP.ServersTransports = make(map[string]*dynamic.ServersTransport)
+			}
+			config.HTTP.ServersTransports[name] = transport
+		}
+	}
+
+	if fragment.TCP != nil {
+		for name, router := range fragment.TCP.Routers {
+			config.TCP.Routers[name] = router
+		}
+		for name, configService := range fragment.TCP.Services {
+			config.TCP.Services[name] = configService
+		}
+		for name, middleware := range fragment.TCP.Middlewares {
+			if config.TCP.Middlewares == nil {
+				config.TCP.Middlewares = make(map[string]*dynamic.TCPMiddleware)
+			}
+			config.TCP.Middlewares[name] = middleware
+		}
+		for name, transport := range fragment.TCP.ServersTransports {
+			if config.TCP.ServersTransports == nil {
+				config.TCP.ServersTransports = make(map[string]*dynamic.TCPServersTransport)
+			}
+			config.TCP.ServersTransports[name] = transport
+		}
+	}
+
+	if fragment.UDP != nil {
+		for name, router := range fragment.UDP.Routers {
+			config.UDP.Routers[name] = router
+		}
+		for name, configService := range fragment.UDP.Services {
+			config.UDP.Services[name] = configService
+		}
+	}
+
+	if fragment.TLS != nil {
+		if config.TLS == nil {
+			config.TLS = &dynamic.TLSConfiguration{}
+		}
+		config.TLS.Certificates = append(config.TLS.Certificates, fragment.TLS.Certificates...)
+		for name, options := range fragment.TLS.Options {
+			if config.TLS.Options == nil {
+				config.TLS.Options = make(map[string]tls.Options)
+			}
+			config.TLS.Options[name] = options
+		}
+		for name, store := range fragment.TLS.Stores {
+			if config.TLS.Stores == nil {
+				config.TLS.Stores = make(map[string]tls.Store)
+			}
+			config.TLS.Stores[name] = store
+		}
+	}
+	return nil
 }
 
 // buildHTTPConfiguration creates default HTTP routers/services and enriches existing ones.
-func buildHTTPConfiguration(httpConfig *dynamic.HTTPConfiguration, service internal.Service, nodeName string) {
-	defaultID := fmt.Sprintf("%s-%d", service.Name, service.ID)
+func buildHTTPConfiguration(httpConfig *dynamic.HTTPConfiguration, service proxmox.Service, nodeName string, opts ConfigurationOptions) {
+	defaultID := serviceID(service.Name, service.ID, service.Config)
 	definedRouters := getDefinedElements(service.Config, "http", "routers")
 	definedServices := getDefinedElements(service.Config, "http", "services")
 
@@ -81,11 +1378,11 @@ func buildHTTPConfiguration(httpConfig *dynamic.HTTPConfiguration, service inter
 
 		// Provide a default rule if none is set
 		if router.Rule == "" {
-			router.Rule = fmt.Sprintf("Host(`%s`)", service.Name)
+			router.Rule = fmt.Sprintf("Host(`%s`)", defaultHost(service))
 		}
 
-		// Set default priority if not set
-		if router.Priority == nil {
+		// Legacy priority mode sets priority 1 on routers without one, instead of Traefik's rule length ordering.
+		if router.Priority == nil && opts.LegacyPriority {
 			defaultPriority := 1
 			router.Priority = &defaultPriority
 		}
@@ -97,31 +1394,119 @@ func buildHTTPConfiguration(httpConfig *dynamic.HTTPConfiguration, service inter
 		if configService == nil {
 			continue
 		}
+		// Mirroring and failover services forward to other services and have no servers of their own.
+		if configService.Mirroring != nil || configService.Failover != nil {
+			continue
+		}
 
 		if configService.LoadBalancer == nil {
 			configService.LoadBalancer = &dynamic.ServersLoadBalancer{}
 		}
 		if configService.LoadBalancer.PassHostHeader == nil {
 			configService.LoadBalancer.PassHostHeader = new(bool)
-			*configService.LoadBalancer.PassHostHeader = true
+			*configService.LoadBalancer.PassHostHeader = !opts.DisablePassHostHeader
 		}
 		if len(configService.LoadBalancer.Servers) == 0 {
 			configService.LoadBalancer.Servers = []dynamic.Server{{}}
 		}
 
-		// Fill in the URL for any server that doesn't have one.
-		for i := range configService.LoadBalancer.Servers {
-			server := &configService.LoadBalancer.Servers[i]
-			if server.URL == "" {
-				server.URL = buildServerURL(service, server, nodeName)
+		// Fill in the URL for any server that doesn't have one, once per guest IP when all IPs are used.
+		path := serverPath(service.Config, serviceName)
+		servers := make([]dynamic.Server, 0, len(configService.LoadBalancer.Servers))
+		for _, server := range configService.LoadBalancer.Servers {
+			if server.URL != "" {
+				servers = append(servers, server)
+				continue
+			}
+			if address, port, ok := natEndpoint(service, "http", serverPort(server)); ok {
+				server.Port = port
+				server.URL = buildServerURL(&server, address, path)
+				servers = append(servers, server)
+				continue
+			}
+			for _, ip := range getServiceIPs(service, nodeName, "http", opts) {
+				server.URL = buildServerURL(&server, ip, path)
+				servers = append(servers, server)
+			}
+		}
+		for i := range servers {
+			// Servers of stopped or draining guests stay visible but never receive traffic.
+			if isServiceDown(service) || service.Draining {
+				servers[i].Weight = new(int)
 			}
 		}
+		configService.LoadBalancer.Servers = servers
 	}
 }
 
+// serviceID returns the name of the default router and service of a guest: the name of its replica group,
+// else its traefik.proxmox.serviceName label, else <name>-<vmid>.
+func serviceID(name string, vmID uint64, labels map[string]string) string {
+	if group := labels[groupLabel]; group != "" {
+		return group
+	}
+	if serviceName := labels[serviceNameLabel]; serviceName != "" {
+		return serviceName
+	}
+	return fmt.Sprintf("%s-%d", sanitizeName(name), vmID)
+}
+
+// sanitizeName turns a guest name into a valid Traefik name and host label made of lowercase letters, digits
+// and dashes, e.g. "My Test_VM" becomes my-test-vm. Names without any of them become guest.
+func sanitizeName(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	sanitized := strings.TrimSuffix(b.String(), "-")
+	if sanitized == "" {
+		return "guest"
+	}
+	return sanitized
+}
+
+// sanitizeHost applies sanitizeName to every label of a host name.
+func sanitizeHost(host string) string {
+	labels := strings.Split(strings.Trim(host, "."), ".")
+	for i, label := range labels {
+		labels[i] = sanitizeName(label)
+	}
+	return strings.Join(labels, ".")
+}
+
+// defaultHost returns the host of the default router rule: the name of the replica group of the guest,
+// else the hostname reported by the guest when known, else the guest name, followed by the domain of the service.
+// Guest names and hostnames are sanitized into valid host names.
+func defaultHost(service proxmox.Service) string {
+	host := sanitizeHost(service.Name)
+	if group := service.Config[groupLabel]; group != "" {
+		host = group
+	} else if service.Hostname != "" {
+		host = sanitizeHost(service.Hostname)
+	}
+	if service.Domain != "" && !strings.HasSuffix(host, "."+service.Domain) {
+		host = host + "." + strings.TrimPrefix(service.Domain, ".")
+	}
+	return host
+}
+
+// isServiceDown reports whether the guest behind the service is known not to be running.
+func isServiceDown(service proxmox.Service) bool {
+	return service.Status != "" && service.Status != "running"
+}
+
 // buildTCPConfiguration enriches TCP routers and services defined in labels.
-func buildTCPConfiguration(tcpConfig *dynamic.TCPConfiguration, service internal.Service, nodeName string) {
-	defaultID := fmt.Sprintf("%s-%d", service.Name, service.ID)
+func buildTCPConfiguration(tcpConfig *dynamic.TCPConfiguration, service proxmox.Service, nodeName string, opts ConfigurationOptions) {
+	defaultID := serviceID(service.Name, service.ID, service.Config)
 
 	definedRouters := getDefinedElements(service.Config, "tcp", "routers")
 	definedServices := getDefinedElements(service.Config, "tcp", "services")
@@ -143,8 +1528,8 @@ func buildTCPConfiguration(tcpConfig *dynamic.TCPConfiguration, service internal
 			router.Service = definedServices[0]
 		}
 
-		// Set default priority if not set
-		if router.Priority == nil {
+		// Legacy priority mode sets priority 1 on routers without one, instead of Traefik's rule length ordering.
+		if router.Priority == nil && opts.LegacyPriority {
 			defaultPriority := 1
 			router.Priority = &defaultPriority
 		}
@@ -170,23 +1555,34 @@ func buildTCPConfiguration(tcpConfig *dynamic.TCPConfiguration, service internal
 		}
 
 		// Fill in the Address for any tcp server that doesn't have one.
-		for i := range configService.LoadBalancer.Servers {
-			server := &configService.LoadBalancer.Servers[i]
-			if server.Address == "" {
-				if server.Port == "" {
-					log.Printf("WARNING: TCP server for service %s has no port defined. Skipping address construction.", service.Name)
-					continue
-				}
-
-				server.Address = buildStreamServerAddress(service, nodeName, server.Port)
+		servers := make([]dynamic.TCPServer, 0, len(configService.LoadBalancer.Servers))
+		for _, server := range configService.LoadBalancer.Servers {
+			if server.Address != "" {
+				servers = append(servers, server)
+				continue
+			}
+			if server.Port == "" {
+				log.Printf("WARNING: TCP server for service %s has no port defined. Skipping address construction.", service.Name)
+				servers = append(servers, server)
+				continue
+			}
+			if address, port, ok := natEndpoint(service, "tcp", server.Port); ok {
+				server.Address = net.JoinHostPort(address, port)
+				servers = append(servers, server)
+				continue
+			}
+			for _, ip := range getServiceIPs(service, nodeName, "tcp", opts) {
+				server.Address = net.JoinHostPort(ip, server.Port)
+				servers = append(servers, server)
 			}
 		}
+		configService.LoadBalancer.Servers = servers
 	}
 }
 
 // buildUDPConfiguration enriches UDP routers and services defined in labels.
-func buildUDPConfiguration(udpConfig *dynamic.UDPConfiguration, service internal.Service, nodeName string) {
-	defaultID := fmt.Sprintf("%s-%d", service.Name, service.ID)
+func buildUDPConfiguration(udpConfig *dynamic.UDPConfiguration, service proxmox.Service, nodeName string, opts ConfigurationOptions) {
+	defaultID := serviceID(service.Name, service.ID, service.Config)
 
 	definedRouters := getDefinedElements(service.Config, "udp", "routers")
 	definedServices := getDefinedElements(service.Config, "udp", "services")
@@ -223,56 +1619,151 @@ func buildUDPConfiguration(udpConfig *dynamic.UDPConfiguration, service internal
 			configService.LoadBalancer.Servers = []dynamic.UDPServer{{}}
 		}
 
-		// Fill in the Address for any tcp server that doesn't have one.
-		for i := range configService.LoadBalancer.Servers {
-			server := &configService.LoadBalancer.Servers[i]
-			if server.Address == "" {
-				if server.Port == "" {
-					log.Printf("WARNING: UDP server for service %s has no port defined. Skipping address construction.", service.Name)
-					continue
-				}
-				server.Address = buildStreamServerAddress(service, nodeName, server.Port)
+		// Fill in the Address for any udp server that doesn't have one.
+		servers := make([]dynamic.UDPServer, 0, len(configService.LoadBalancer.Servers))
+		for _, server := range configService.LoadBalancer.Servers {
+			if server.Address != "" {
+				servers = append(servers, server)
+				continue
+			}
+			if server.Port == "" {
+				log.Printf("WARNING: UDP server for service %s has no port defined. Skipping address construction.", service.Name)
+				servers = append(servers, server)
+				continue
+			}
+			if address, port, ok := natEndpoint(service, "udp", server.Port); ok {
+				server.Address = net.JoinHostPort(address, port)
+				servers = append(servers, server)
+				continue
+			}
+			for _, ip := range getServiceIPs(service, nodeName, "udp", opts) {
+				server.Address = net.JoinHostPort(ip, server.Port)
+				servers = append(servers, server)
 			}
 		}
+		configService.LoadBalancer.Servers = servers
 	}
 }
 
-// buildServerURL constructs the final URL for an HTTP server.
-func buildServerURL(service internal.Service, server *dynamic.Server, nodeName string) string {
+// buildServerURL constructs the final URL for an HTTP server listening on ip, followed by the path label of
+// its service.
+func buildServerURL(server *dynamic.Server, ip, path string) string {
+	// User-defined scheme from labels takes precedence. h2c is cleartext HTTP/2, e.g. for gRPC backends.
 	scheme := "http"
-	port := "80"
+	if server.Scheme == "https" || server.Scheme == "h2c" {
+		scheme = server.Scheme
+	}
 
-	// User-defined scheme from labels takes precedence.
-	if server.Scheme == "https" {
-		scheme = "https"
-		port = "443"
+	serverURL := fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(ip, serverPort(*server)))
+	if path != "" {
+		serverURL += "/" + strings.TrimPrefix(path, "/")
+		// Traefik drops the path of a server URL unless it's told to preserve it.
+		server.PreservePath = true
 	}
+	return serverURL
+}
 
-	if server.Port != "" {
-		port = server.Port
+// serverPort returns the port of an HTTP server, the default port of its scheme when it has none.
+func serverPort(server dynamic.Server) string {
+	switch {
+	case server.Port != "":
+		return server.Port
+	case server.Scheme == "https":
+		return "443"
+	default:
+		return "80"
 	}
+}
 
-	ip := getServiceIP(service, nodeName)
-	return fmt.Sprintf("%s://%s:%s", scheme, ip, port)
+// natEndpoint returns the node address and port a server of the guest is reached through in NAT mode: the
+// node port forwarded to the port of the guest, or the same port when none is. It returns false when the
+// guest is reached directly, outside NAT mode or when an ip label pins its address.
+func natEndpoint(service proxmox.Service, protocol, port string) (string, string, bool) {
+	if service.NodeAddress == "" || service.Config["traefik.proxmox."+protocol+".ip"] != "" || service.Config[ipLabel] != "" {
+		return "", "", false
+	}
+	transport := "tcp"
+	if protocol == "udp" {
+		transport = "udp"
+	}
+	key := port + "/" + transport
+	if forwarded := parseForwardedPorts(service.Config[natPortsLabel])[key]; forwarded != "" {
+		return service.NodeAddress, forwarded, true
+	}
+	if forwarded := service.ForwardedPorts[key]; forwarded != "" {
+		return service.NodeAddress, forwarded, true
+	}
+	return service.NodeAddress, port, true
+}
+
+// parseForwardedPorts parses a list of node:guest port mappings in the Docker layout, e.g. 8080:80,5353:53/udp,
+// into the node port of every guest port and transport. Invalid mappings are ignored.
+func parseForwardedPorts(value string) map[string]string {
+	ports := make(map[string]string)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		transport := "tcp"
+		if i := strings.LastIndex(item, "/"); i >= 0 {
+			item, transport = item[:i], strings.ToLower(item[i+1:])
+		}
+		nodePort, guestPort, ok := strings.Cut(item, ":")
+		if !ok || nodePort == "" || guestPort == "" {
+			continue
+		}
+		ports[guestPort+"/"+transport] = nodePort
+	}
+	return ports
 }
 
-// buildStreamServerAddress constructs the final address for a TCP or UDP server.
-func buildStreamServerAddress(service internal.Service, nodeName string, port string) string {
-	ip := getServiceIP(service, nodeName)
-	return fmt.Sprintf("%s:%s", ip, port)
+// getServiceIPs returns the addresses servers without an explicit URL or address are created for:
+// every valid IP of the guest when all IPs are used and no address is pinned, else the single best one.
+func getServiceIPs(service proxmox.Service, nodeName, protocol string, opts ConfigurationOptions) []string {
+	all := opts.AllIPs
+	if value, ok := service.Config[allIPsLabel]; ok {
+		all = value == "true"
+	}
+	pinned := service.Config["traefik.proxmox."+protocol+".ip"] != "" || service.Config[ipLabel] != ""
+	if !all || pinned {
+		return []string{getServiceIP(service, nodeName, protocol)}
+	}
+
+	var ips []string
+	for _, ip := range service.IPs {
+		if ip.Address != "" && ip.Address != "127.0.0.1" && ip.Address != "::1" {
+			ips = append(ips, ip.Address)
+		}
+	}
+	if len(ips) == 0 {
+		return []string{getServiceIP(service, nodeName, protocol)}
+	}
+	return ips
 }
 
 // getServiceIP finds the best IP address for a service, falling back to hostname.
-func getServiceIP(service internal.Service, nodeName string) string {
+// The traefik.proxmox.<protocol>.ip and traefik.proxmox.ip labels pin the address explicitly.
+func getServiceIP(service proxmox.Service, nodeName, protocol string) string {
+	if ip := service.Config["traefik.proxmox."+protocol+".ip"]; ip != "" {
+		return ip
+	}
+	if ip := service.Config[ipLabel]; ip != "" {
+		return ip
+	}
+
 	// Use the first valid IP from the guest agent.
 	for _, ip := range service.IPs {
-		if ip.Address != "" && ip.Address != "127.0.0.1" {
+		if ip.Address != "" && ip.Address != "127.0.0.1" && ip.Address != "::1" {
 			return ip.Address
 		}
 	}
 	// Fall back to a DNS-resolvable name.
-	log.Printf("WARNING: No valid IP found for service %s via guest agent. Falling back to hostname '%s.%s'. Ensure DNS is configured.", service.Name, service.Name, nodeName)
-	return fmt.Sprintf("%s.%s", service.Name, nodeName)
+	hostname := fallbackHostname(service, nodeName)
+	log.Printf("WARNING: No valid IP found for service %s via guest agent. Falling back to hostname '%s'. Ensure DNS is configured.", service.Name, hostname)
+	return hostname
+}
+
+// fallbackHostname returns the <name>.<node> hostname used for guests without a known IP.
+func fallbackHostname(service proxmox.Service, nodeName string) string {
+	return sanitizeName(service.Name) + "." + nodeName
 }
 
 // getDefinedElements finds all uniquely named routers or services from labels.
@@ -292,5 +1783,6 @@ func getDefinedElements(labels map[string]string, proto, elemType string) []stri
 	for k := range keys {
 		uniqueKeys = append(uniqueKeys, k)
 	}
+	sort.Strings(uniqueKeys)
 	return uniqueKeys
 }
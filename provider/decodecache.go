@@ -0,0 +1,142 @@
+package provider
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/NX211/traefik-proxmox-provider/dynamic"
+)
+
+// DecodeCache remembers the decoded labels of guests between polls, keyed by a digest of the labels, so the
+// labels of unchanged guests aren't decoded again on every poll. A nil cache stores nothing.
+type DecodeCache struct {
+	mu sync.Mutex
+	// entries are the decodes of the previous pass, next those used during the current one.
+	entries map[string]*decodeEntry
+	next    map[string]*decodeEntry
+}
+
+type decodeEntry struct {
+	config *dynamic.Configuration
+	err    error
+}
+
+// NewDecodeCache creates an empty cache.
+func NewDecodeCache() *DecodeCache {
+	return &DecodeCache{entries: make(map[string]*decodeEntry), next: make(map[string]*decodeEntry)}
+}
+
+// decode fills config, an empty configuration, with the decoded labels. Decodes are cached with their
+// error, and every caller gets its own copy, as the builders modify the configuration afterwards.
+func (c *DecodeCache) decode(config *dynamic.Configuration, labels map[string]string) error {
+	if c == nil {
+		return decodeGuestLabels(config, labels)
+	}
+
+	digest := labelsDigest(labels)
+	c.mu.Lock()
+	entry, ok := c.next[digest]
+	if !ok {
+		entry, ok = c.entries[digest]
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		decoded := newConfiguration()
+		entry = &decodeEntry{config: decoded, err: decodeGuestLabels(decoded, labels)}
+	}
+
+	c.mu.Lock()
+	c.next[digest] = entry
+	c.mu.Unlock()
+
+	*config = *copyConfiguration(entry.config)
+	return entry.err
+}
+
+// flush ends a pass, dropping the decodes that weren't used during it, e.g. those of removed guests or of
+// labels that changed since.
+func (c *DecodeCache) flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = c.next
+	c.next = make(map[string]*decodeEntry, len(c.entries))
+}
+
+// labelsDigest returns a digest of the labels, independent of their order.
+func labelsDigest(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+		hash.Write([]byte(labels[key]))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// copyConfiguration returns a deep copy of a configuration, keeping the difference between nil and empty
+// values and the fields left out of its JSON, such as the port of servers.
+func copyConfiguration(config *dynamic.Configuration) *dynamic.Configuration {
+	return copyValue(reflect.ValueOf(config)).Interface().(*dynamic.Configuration)
+}
+
+func copyValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return reflect.Zero(v.Type())
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(copyValue(v.Elem()))
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			if field := c.Field(i); field.CanSet() {
+				field.Set(copyValue(v.Field(i)))
+			}
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return reflect.Zero(v.Type())
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(copyValue(v.Index(i)))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return reflect.Zero(v.Type())
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			c.SetMapIndex(iter.Key(), copyValue(iter.Value()))
+		}
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return reflect.Zero(v.Type())
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(copyValue(v.Elem()))
+		return c
+	default:
+		return v
+	}
+}
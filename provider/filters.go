@@ -0,0 +1,421 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/proxmox"
+)
+
+// Guest types as named by the Proxmox API.
+const (
+	guestTypeQemu = "qemu"
+	guestTypeLXC  = "lxc"
+)
+
+// Ways of handling the guests of nodes in maintenance.
+const (
+	maintenanceModeDrain = "drain"
+	maintenanceModeDrop  = "drop"
+)
+
+// DiscoveryOptions restricts which nodes and guests are scanned by GetServiceMap.
+// The zero value scans everything.
+type DiscoveryOptions struct {
+	// Nodes limits the discovery to these nodes. All nodes are scanned when empty.
+	Nodes []string
+	// ExcludeNodes are never scanned, even when listed in Nodes.
+	ExcludeNodes []string
+	// Pools limits the discovery to guests belonging to these resource pools.
+	Pools []string
+	// ExcludePools lists resource pools whose guests are never scanned.
+	ExcludePools []string
+	// Tags limits the discovery to guests carrying at least one of these Proxmox tags.
+	// Such guests are enabled even without a traefik.enable=true label.
+	Tags []string
+	// ExcludeTags lists Proxmox tags whose guests are never discovered.
+	ExcludeTags []string
+	// VMIDRanges limits the discovery to guests whose VMID falls in one of these ranges.
+	VMIDRanges []VMIDRange
+	// NameFilter limits the discovery to guests whose name matches this expression.
+	NameFilter *regexp.Regexp
+	// NameExclude skips guests whose name matches this expression.
+	NameExclude *regexp.Regexp
+	// GuestTypes limits the discovery to "qemu" VMs or "lxc" containers. Both are scanned when empty.
+	GuestTypes []string
+	// IncludeTemplates scans VM and container templates, which are skipped by default.
+	IncludeTemplates bool
+	// IncludeLocked scans guests with an active lock (e.g. clone, create, migrate), which are skipped by default.
+	// Backup and snapshot locks never cause a guest to be skipped.
+	IncludeLocked bool
+	// IncludeStopped keeps stopped guests in the configuration, with their HTTP servers at weight 0.
+	IncludeStopped bool
+	// RequireAgentPing skips running VMs whose QEMU guest agent doesn't answer a ping.
+	RequireAgentPing bool
+	// MaintenanceNodes are treated as being in maintenance.
+	MaintenanceNodes []string
+	// DetectHAMaintenance also treats nodes the HA manager reports in maintenance mode as being in maintenance.
+	DetectHAMaintenance bool
+	// MaintenanceMode is "drain" (the default) to keep the guests of nodes in maintenance with their
+	// HTTP servers at weight 0, or "drop" to leave them out of the configuration.
+	MaintenanceMode string
+	// IPFamily selects the address family of the servers: ipv4 (the default), ipv6, prefer-ipv6 or dual.
+	IPFamily string
+	// DefaultInterface is the network interface the IPs are taken from when a guest has no
+	// traefik.proxmox.interface label. Any interface is used when empty.
+	DefaultInterface string
+	// PreferredCIDRs are the subnets server IPs are preferably taken from, in order of preference.
+	PreferredCIDRs []*net.IPNet
+	// ExcludedCIDRs are subnets whose addresses are never used as server IPs.
+	ExcludedCIDRs []*net.IPNet
+	// MACResolvers are asked, in order, for the addresses bound to the MAC addresses of guests
+	// whose addresses couldn't be discovered otherwise.
+	MACResolvers []MACResolver
+	// UseGuestHostname uses the hostname reported by the guest agent (VMs) or set on the container
+	// for the default Host rule instead of the guest name.
+	UseGuestHostname bool
+	// GuestLabelFile is the path of a label file read inside running VMs through the guest agent.
+	// Its labels are merged with the ones from the notes. Disabled when empty.
+	GuestLabelFile string
+	// PublishAll adds a TCP router and service for every port running VMs listen on, found through the
+	// guest agent.
+	PublishAll bool
+	// NATMode reaches guests through the address of their node and the ports forwarded to them, for guests
+	// on a NATed bridge that is only reachable through the Proxmox host.
+	NATMode bool
+	// NodeAddresses are the addresses of the nodes in NAT mode and for the web interface, by node name. Nodes without one use the
+	// address reported in the cluster status.
+	NodeAddresses map[string]string
+	// NATPorts are the node ports forwarded to the guests in NAT mode, as a natPorts label value by VMID.
+	NATPorts map[string]string
+	// LegacyContainerIPs skips the container interfaces endpoint, which needs Proxmox VE 8.2 or later,
+	// and only uses the addresses statically configured on the container network devices.
+	LegacyContainerIPs bool
+	// HostnameFallback controls the <name>.<node> hostname used for guests without a known IP:
+	// "unchecked" uses it as is, "log" (the default, also when empty) warns when it doesn't resolve
+	// and "skip" leaves such guests out.
+	HostnameFallback string
+	// Protocols are the protocols the configuration is generated for, only their servers are checked
+	// against the hostname fallback. Empty checks them all.
+	Protocols []string
+	// DefaultDomain is appended to the host of the default Host rule, e.g. Host(`<name>.<DefaultDomain>`).
+	DefaultDomain string
+	// SnippetPaths maps storages to the local directory they are mounted at, for configRef snippets.
+	SnippetPaths map[string]string
+	// NodeDomains overrides DefaultDomain for the guests of a node.
+	NodeDomains map[string]string
+	// PoolDomains overrides DefaultDomain and NodeDomains for the guests of a resource pool.
+	PoolDomains map[string]string
+	// Defaults are default labels of all guests, beneath their node, pool and own labels.
+	Defaults map[string]string
+	// NodeLabels are default labels of the guests of a node, beneath their own labels.
+	NodeLabels map[string]map[string]string
+	// PoolLabels are default labels of the guests of a resource pool, beneath their own labels.
+	PoolLabels map[string]map[string]string
+	// PoolCommentLabels also reads default labels from the comments of resource pools.
+	// PoolLabels take precedence over them.
+	PoolCommentLabels bool
+	// IPCache keeps the IPs of locked guests whose agent can't be queried, and the IPs reported by the agents
+	// for the TTL of the cache. Nil disables the cache.
+	IPCache *IPCache
+	// AgentBackoff stops querying the agents of VMs that keep failing on every poll. Nil queries them every time.
+	AgentBackoff *AgentBackoff
+	// NodeCache refreshes the node list on its own interval. Nil lists the nodes on every poll.
+	NodeCache *NodeCache
+}
+
+// isTransientLock reports whether a lock is taken on a working guest, e.g. by vzdump,
+// as opposed to a guest that is still being created or cloned.
+func isTransientLock(lock string) bool {
+	switch lock {
+	case "backup", "snapshot", "snapshot-delete":
+		return true
+	}
+	return false
+}
+
+// VMIDRange is an inclusive range of guest IDs.
+type VMIDRange struct {
+	Min uint64
+	Max uint64
+}
+
+// ParseVMIDRanges parses a comma separated list of IDs and ID ranges, e.g. "100-199,500".
+func ParseVMIDRanges(value string) ([]VMIDRange, error) {
+	var ranges []VMIDRange
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		bounds := strings.SplitN(part, "-", 2)
+		minID, err := strconv.ParseUint(strings.TrimSpace(bounds[0]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid VMID %q", bounds[0])
+		}
+		maxID := minID
+		if len(bounds) == 2 {
+			maxID, err = strconv.ParseUint(strings.TrimSpace(bounds[1]), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid VMID %q", bounds[1])
+			}
+		}
+		if maxID < minID {
+			return nil, fmt.Errorf("invalid VMID range %q: end is lower than start", part)
+		}
+		ranges = append(ranges, VMIDRange{Min: minID, Max: maxID})
+	}
+	return ranges, nil
+}
+
+// includesVMID reports whether the VMID falls in one of the configured ranges.
+func (o DiscoveryOptions) includesVMID(vmID uint64) bool {
+	if len(o.VMIDRanges) == 0 {
+		return true
+	}
+	for _, r := range o.VMIDRanges {
+		if vmID >= r.Min && vmID <= r.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// includesNode reports whether the node should be scanned.
+func (o DiscoveryOptions) includesNode(name string) bool {
+	if containsString(o.ExcludeNodes, name) {
+		return false
+	}
+	return len(o.Nodes) == 0 || containsString(o.Nodes, name)
+}
+
+// includesGuestType reports whether guests of the given type should be scanned.
+func (o DiscoveryOptions) includesGuestType(guestType string) bool {
+	return len(o.GuestTypes) == 0 || containsFold(o.GuestTypes, guestType)
+}
+
+// maintenanceNodes returns the nodes currently in maintenance. A failing HA status lookup is logged
+// and ignored, so that missing permissions don't break the discovery.
+func (o DiscoveryOptions) maintenanceNodes(client *proxmox.ProxmoxClient, ctx context.Context) map[string]bool {
+	nodes := make(map[string]bool, len(o.MaintenanceNodes))
+	for _, name := range o.MaintenanceNodes {
+		nodes[name] = true
+	}
+
+	if o.DetectHAMaintenance {
+		haStatus, err := client.GetHANodeStatus(ctx)
+		if err != nil {
+			log.Printf("Error getting HA node status, only using the configured maintenance nodes: %v", err)
+		}
+		for name, state := range haStatus {
+			if state == "maintenance" {
+				nodes[name] = true
+			}
+		}
+	}
+	return nodes
+}
+
+// nodeAddresses returns the address of every node, e.g. for NAT mode: the configured one, else the address
+// the cluster status reports for it.
+func (o DiscoveryOptions) nodeAddresses(client *proxmox.ProxmoxClient, ctx context.Context) map[string]string {
+	status, err := client.GetClusterStatus(ctx)
+	if err != nil {
+		log.Printf("Error getting the cluster status, only using the configured node addresses: %v", err)
+	}
+	return o.clusterNodeAddresses(status)
+}
+
+// clusterNodeAddresses returns the addresses of the nodes in the cluster status, overridden by NodeAddresses.
+func (o DiscoveryOptions) clusterNodeAddresses(status []proxmox.ClusterStatus) map[string]string {
+	addresses := make(map[string]string)
+	for _, entry := range status {
+		if entry.Type == "node" && entry.IP != "" {
+			addresses[entry.Name] = entry.IP
+		}
+	}
+	for name, address := range o.NodeAddresses {
+		addresses[name] = address
+	}
+	return addresses
+}
+
+// offlineNodes returns the nodes the cluster status reports as offline, e.g. powered off or on the other side
+// of a network partition, whose API calls would only time out. A cluster without quorum is logged: the nodes
+// still online are scanned, their guests keep running even if the cluster configuration is read-only. The
+// status of a standalone node has no cluster entry, its node is never skipped.
+func offlineNodes(status []proxmox.ClusterStatus) map[string]bool {
+	offline := make(map[string]bool)
+	clustered := false
+	for _, entry := range status {
+		switch entry.Type {
+		case "cluster":
+			clustered = true
+			if entry.Quorate == 0 {
+				log.Printf("WARNING: Cluster %s has no quorum, only scanning the nodes that are online", entry.Name)
+			}
+		case "node":
+			if entry.Online == 0 {
+				offline[entry.Name] = true
+			}
+		}
+	}
+	if !clustered {
+		return nil
+	}
+	return offline
+}
+
+// needsPools reports whether the pool membership of guests has to be fetched.
+func (o DiscoveryOptions) needsPools() bool {
+	return len(o.Pools) > 0 || len(o.ExcludePools) > 0 || len(o.PoolDomains) > 0 || len(o.PoolLabels) > 0 || o.PoolCommentLabels
+}
+
+// guestFilter applies the DiscoveryOptions to the guests of a single poll.
+type guestFilter struct {
+	opts DiscoveryOptions
+	// pools maps VMIDs to their resource pool; only populated when pool filters are set.
+	pools map[uint64]string
+	// poolCommentLabels holds the labels of the pool comments; only populated when PoolCommentLabels is set.
+	poolCommentLabels map[string]map[string]string
+	// storagePaths caches the directories of the storages holding snippets.
+	storagePaths map[string]string
+	// seen holds the VMIDs of the guests listed on the scanned nodes, whether they were scanned or not.
+	seen map[uint64]bool
+}
+
+// see records a guest listed on a node.
+func (f *guestFilter) see(vmID uint64) {
+	if f.seen == nil {
+		f.seen = make(map[uint64]bool)
+	}
+	f.seen[vmID] = true
+}
+
+func newGuestFilter(client *proxmox.ProxmoxClient, ctx context.Context, opts DiscoveryOptions) (*guestFilter, error) {
+	filter := &guestFilter{opts: opts}
+
+	if opts.needsPools() {
+		resources, err := client.GetClusterResources(ctx, "vm")
+		if err != nil {
+			return nil, fmt.Errorf("error getting pool membership: %w", err)
+		}
+		filter.pools = make(map[uint64]string, len(resources))
+		for _, resource := range resources {
+			filter.pools[resource.VMID] = resource.Pool
+		}
+	}
+
+	if opts.PoolCommentLabels {
+		pools, err := client.GetPools(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error getting pools: %w", err)
+		}
+		filter.poolCommentLabels = make(map[string]map[string]string, len(pools))
+		for _, pool := range pools {
+			filter.poolCommentLabels[pool.PoolID] = proxmox.ParseLabels(pool.Comment)
+		}
+	}
+
+	return filter, nil
+}
+
+// includes reports whether the guest should be scanned, and why not otherwise.
+func (f *guestFilter) includes(guest guestRef) (bool, string) {
+	if guest.Template && !f.opts.IncludeTemplates {
+		return false, "it is a template"
+	}
+	if guest.Lock != "" && !isTransientLock(guest.Lock) && !f.opts.IncludeLocked {
+		return false, fmt.Sprintf("it is locked (%s)", guest.Lock)
+	}
+	if !f.opts.includesVMID(guest.VMID) {
+		return false, "its VMID is outside of the configured ranges"
+	}
+	if f.opts.NameExclude != nil && f.opts.NameExclude.MatchString(guest.Name) {
+		return false, "its name matches the name exclusion"
+	}
+	if f.opts.NameFilter != nil && !f.opts.NameFilter.MatchString(guest.Name) {
+		return false, "its name does not match the name filter"
+	}
+	if f.opts.needsPools() {
+		pool := f.pools[guest.VMID]
+		if containsString(f.opts.ExcludePools, pool) {
+			return false, fmt.Sprintf("pool %q is excluded", pool)
+		}
+		if len(f.opts.Pools) > 0 && !containsString(f.opts.Pools, pool) {
+			return false, fmt.Sprintf("pool %q is not included", pool)
+		}
+	}
+	return true, ""
+}
+
+// includesTags reports whether a guest with the given tags should be discovered, and why not otherwise.
+// Unlike includes, it can only be checked once the guest configuration has been fetched.
+func (f *guestFilter) includesTags(tags []string) (bool, string) {
+	for _, tag := range tags {
+		if containsFold(f.opts.ExcludeTags, tag) {
+			return false, fmt.Sprintf("tag %q is excluded", tag)
+		}
+	}
+	if len(f.opts.Tags) > 0 && !f.optedInByTag(tags) {
+		return false, "it has none of the required tags"
+	}
+	return true, ""
+}
+
+// domain returns the domain of the default Host rule of a guest: the one of its pool,
+// else the one of its node, else the default domain.
+func (f *guestFilter) domain(guest guestRef) string {
+	if domain, ok := f.opts.PoolDomains[f.pools[guest.VMID]]; ok {
+		return domain
+	}
+	if domain, ok := f.opts.NodeDomains[guest.Node]; ok {
+		return domain
+	}
+	return f.opts.DefaultDomain
+}
+
+// optedInByTag reports whether one of the tags enables the guest for Traefik.
+func (f *guestFilter) optedInByTag(tags []string) bool {
+	for _, tag := range tags {
+		if containsFold(f.opts.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// isEnabled reports whether a guest opted in, through the traefik.enable label or a required tag.
+// An explicit traefik.enable=false opts the guest out, even when it carries a required tag.
+func (f *guestFilter) isEnabled(labels map[string]string, tags []string) bool {
+	if value, ok := labels["traefik.enable"]; ok {
+		if enabled, err := strconv.ParseBool(value); err == nil && !enabled {
+			return false
+		}
+	}
+	return isBoolLabelEnabled(labels, "traefik.enable") || f.optedInByTag(tags)
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
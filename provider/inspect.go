@@ -0,0 +1,149 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/dynamic"
+	"github.com/NX211/traefik-proxmox-provider/proxmox"
+	"github.com/traefik/paerser/parser"
+)
+
+// GuestReport describes how the labels of a single guest are interpreted by the provider.
+type GuestReport struct {
+	Node          string                 `json:"node"`
+	VMID          uint64                 `json:"vmid"`
+	Name          string                 `json:"name"`
+	Type          string                 `json:"type"`
+	Status        string                 `json:"status"`
+	Enabled       bool                   `json:"enabled"`
+	Tags          []string               `json:"tags,omitempty"`
+	Labels        map[string]string      `json:"labels"`
+	IPs           []proxmox.IP           `json:"ips"`
+	DecodeError   string                 `json:"decodeError,omitempty"`
+	UnknownKeys   []string               `json:"unknownKeys,omitempty"`
+	InvalidLabels map[string]string      `json:"invalidLabels,omitempty"`
+	Configuration *dynamic.Configuration `json:"configuration,omitempty"`
+}
+
+// HasProblems reports whether any label could not be decoded.
+func (r *GuestReport) HasProblems() bool {
+	return r.DecodeError != "" || len(r.UnknownKeys) > 0 || len(r.InvalidLabels) > 0
+}
+
+// InspectGuest fetches the configuration of a single guest, decodes its traefik labels and
+// returns the routers and services they produce, without sending anything to Traefik.
+func (p *Provider) InspectGuest(ctx context.Context, vmID uint64) (*GuestReport, error) {
+	guest, err := findGuest(p.client, ctx, vmID)
+	if err != nil {
+		return nil, err
+	}
+
+	var config *proxmox.ParsedConfig
+	if guest.IsContainer {
+		config, err = p.client.GetContainerConfig(ctx, guest.Node, guest.VMID)
+	} else {
+		config, err = p.client.GetVMConfig(ctx, guest.Node, guest.VMID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting config of guest %d: %w", vmID, err)
+	}
+
+	filter, err := newGuestFilter(p.client, ctx, p.discovery)
+	if err != nil {
+		return nil, err
+	}
+	labels := filter.guestLabels(p.client, ctx, *guest, config)
+	tags := config.GetTags()
+	report := &GuestReport{
+		Node:    guest.Node,
+		VMID:    guest.VMID,
+		Name:    guest.Name,
+		Type:    guestTypeQemu,
+		Status:  guest.Status,
+		Enabled: filter.isEnabled(labels, tags),
+		Tags:    tags,
+		Labels:  labels,
+	}
+	if guest.IsContainer {
+		report.Type = guestTypeLXC
+	}
+
+	if guest.Status == "running" {
+		ips, err := getIPsOfService(p.client, ctx, guest.Node, guest.VMID, guest.IsContainer, p.discovery, labels)
+		if err == nil {
+			report.IPs = ips
+		}
+		if len(report.IPs) == 0 {
+			beginPoll(p.discovery.MACResolvers)
+			report.IPs = fallbackIPs(ctx, config, guest.VMID, p.discovery, labels)
+		}
+	}
+
+	untyped, serviceTypeLabels := splitServiceTypeLabels(labels)
+	untyped, serverLabels := splitLabels(untyped, isIndexedServerLabel)
+	if err := parser.Decode(untyped, &dynamic.Configuration{}, "traefik", "traefik.http", "traefik.tcp", "traefik.udp"); err != nil {
+		report.DecodeError = err.Error()
+	} else if err := decodeServiceTypeLabels(newConfiguration(), serviceTypeLabels); err != nil {
+		report.DecodeError = err.Error()
+	} else if err := decodeIndexedServerLabels(newConfiguration(), serverLabels); err != nil {
+		report.DecodeError = err.Error()
+	}
+	report.UnknownKeys, report.InvalidLabels = checkLabels(labels)
+
+	service := proxmox.NewService(guest.VMID, guest.Name, labels)
+	service.IPs = report.IPs
+	service.Certificates = filter.guestCertificates(p.client, ctx, guest.VMID, labels)
+	service.Status = guest.Status
+	service.Domain = filter.domain(*guest)
+	if p.discovery.UseGuestHostname {
+		service.Hostname = config.Hostname
+		if !guest.IsContainer && guest.Status == "running" {
+			_ = p.discovery.AgentBackoff.query(guest.VMID, guest.Uptime, func() (err error) {
+				service.Hostname, err = p.client.GetVMHostname(ctx, guest.Node, guest.VMID)
+				return err
+			})
+		}
+	}
+	var guestErrors []GuestError
+	report.Configuration, guestErrors = BuildConfiguration(map[string][]proxmox.Service{guest.Node: {service}}, p.generation)
+	if len(guestErrors) > 0 && report.DecodeError == "" {
+		report.DecodeError = guestErrors[0].Error
+	}
+
+	return report, nil
+}
+
+// checkLabels decodes every label on its own to find keys that don't map to any
+// configuration field and values that can't be parsed.
+func checkLabels(labels map[string]string) ([]string, map[string]string) {
+	var unknown []string
+	invalid := make(map[string]string)
+
+	for key, value := range labels {
+		var err error
+		if isServerPathLabel(key) {
+			continue
+		}
+		if isServiceTypeLabel(key) {
+			err = parser.Decode(map[string]string{key: value}, &httpServiceLabels{}, "traefik", "traefik.http.services")
+		} else if isIndexedServerLabel(key) {
+			err = decodeIndexedServerLabels(newConfiguration(), map[string]string{key: value})
+		} else {
+			err = parser.Decode(map[string]string{key: value}, &dynamic.Configuration{}, "traefik", "traefik.http", "traefik.tcp", "traefik.udp")
+		}
+		if err == nil {
+			continue
+		}
+		if strings.Contains(err.Error(), "field not found") {
+			unknown = append(unknown, key)
+			continue
+		}
+		invalid[key] = err.Error()
+	}
+
+	sort.Strings(unknown)
+	return unknown, invalid
+}
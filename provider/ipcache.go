@@ -0,0 +1,136 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/NX211/traefik-proxmox-provider/proxmox"
+)
+
+// IPCache remembers the IPs discovered for each guest between polls. A nil cache stores nothing.
+type IPCache struct {
+	mu  sync.Mutex
+	ips map[uint64][]proxmox.IP
+
+	// agent keeps the IPs reported by the guest agents, apart from the IPs of any source kept in ips.
+	agent map[uint64]agentIPs
+	// ttl is how long the IPs reported by an agent replace a failed or empty agent query.
+	ttl time.Duration
+	// refresh is how long the IPs reported by an agent are used before the agent is queried again.
+	refresh time.Duration
+	now     func() time.Time
+}
+
+type agentIPs struct {
+	ips     []proxmox.IP
+	fetched time.Time
+}
+
+// NewIPCache creates an empty cache.
+func NewIPCache() *IPCache {
+	return &IPCache{ips: make(map[uint64][]proxmox.IP), agent: make(map[uint64]agentIPs), now: time.Now}
+}
+
+// newIPCache creates an empty cache keeping the IPs reported by the guest agents for the ttl, and only
+// querying the agents again after the refresh interval. Both are durations, empty to disable them.
+func newIPCache(ttl, refresh string) (*IPCache, error) {
+	cache := NewIPCache()
+	var err error
+	if ttl != "" {
+		if cache.ttl, err = time.ParseDuration(ttl); err != nil {
+			return nil, fmt.Errorf("invalid TTL: %w", err)
+		}
+	}
+	if refresh != "" {
+		if cache.refresh, err = time.ParseDuration(refresh); err != nil {
+			return nil, fmt.Errorf("invalid refresh interval: %w", err)
+		}
+	}
+	if cache.refresh > cache.ttl {
+		cache.ttl = cache.refresh
+	}
+	return cache, nil
+}
+
+// resolve returns the IPs to use for a guest. Freshly discovered IPs are stored; when none could be
+// discovered while the guest is locked (e.g. by vzdump), the IPs of a previous poll are reused.
+func (c *IPCache) resolve(vmID uint64, lock string, ips []proxmox.IP) []proxmox.IP {
+	if c == nil {
+		return ips
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(ips) > 0 {
+		c.ips[vmID] = ips
+		return ips
+	}
+	if cached, ok := c.ips[vmID]; ok && lock != "" {
+		log.Printf("Using cached IPs for guest %d because it is locked (%s)", vmID, lock)
+		return cached
+	}
+	return ips
+}
+
+// prune drops the IPs of the guests that aren't in seen.
+func (c *IPCache) prune(seen map[uint64]bool) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for vmID := range c.ips {
+		if !seen[vmID] {
+			delete(c.ips, vmID)
+		}
+	}
+	for vmID := range c.agent {
+		if !seen[vmID] {
+			delete(c.agent, vmID)
+		}
+	}
+}
+
+// agentIPs returns the IPs reported by the agent of a guest, calling query to ask the agent. IPs reported
+// less than the refresh interval ago are used without querying the agent, and when the query fails or
+// returns no IPs, those reported less than the TTL ago are used instead, so a hiccup of the agent doesn't
+// switch the guest to the fallback addresses.
+func (c *IPCache) agentIPs(vmID uint64, query func() ([]proxmox.IP, error)) []proxmox.IP {
+	if c == nil || c.ttl == 0 {
+		ips, err := query()
+		if err != nil {
+			return nil
+		}
+		return ips
+	}
+
+	c.mu.Lock()
+	cached, ok := c.agent[vmID]
+	c.mu.Unlock()
+	if ok && c.now().Sub(cached.fetched) < c.refresh {
+		return cached.ips
+	}
+
+	ips, err := query()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil && len(ips) > 0 {
+		c.agent[vmID] = agentIPs{ips: ips, fetched: c.now()}
+		return ips
+	}
+	if ok {
+		if age := c.now().Sub(cached.fetched); age < c.ttl {
+			log.Printf("Using the IPs the agent of guest %d reported %s ago, as it returned none", vmID, age.Round(time.Second))
+			return cached.ips
+		}
+		delete(c.agent, vmID)
+	}
+	if err != nil {
+		return nil
+	}
+	return ips
+}
@@ -0,0 +1,123 @@
+package provider
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/proxmox"
+)
+
+// interfaceLabel selects the network interface the IPs of a guest are taken from.
+const interfaceLabel = "traefik.proxmox.interface"
+
+// defaultExcludedCIDRs are never used as server IPs unless overridden: link-local, CGNAT
+// and the default Docker bridge addresses reported by guests running Docker.
+var defaultExcludedCIDRs = []string{"169.254.0.0/16", "fe80::/10", "100.64.0.0/10", "172.17.0.0/16"}
+
+// ipLabel pins the server address of a guest, skipping the IP lookup.
+const ipLabel = "traefik.proxmox.ip"
+
+// allIPsLabel overrides the allIPs option for a guest.
+const allIPsLabel = "traefik.proxmox.allIPs"
+
+// natPortsLabel lists the node ports forwarded to the ports of a guest in NAT mode, e.g. 8080:80,5353:53/udp.
+const natPortsLabel = "traefik.proxmox.natPorts"
+
+// Address families accepted by the ipFamily option.
+const (
+	ipFamilyIPv4       = "ipv4"
+	ipFamilyIPv6       = "ipv6"
+	ipFamilyPreferIPv6 = "prefer-ipv6"
+	ipFamilyDual       = "dual"
+)
+
+// isIPv6 reports whether an address reported by the guest agent or the container
+// interfaces endpoint is an IPv6 address.
+func isIPv6(ip proxmox.IP) bool {
+	switch ip.AddressType {
+	case "ipv6", "inet6":
+		return true
+	case "ipv4", "inet":
+		return false
+	}
+	parsed := net.ParseIP(ip.Address)
+	return parsed != nil && parsed.To4() == nil
+}
+
+// guestInterface returns the network interface the IPs of a guest are taken from: the
+// traefik.proxmox.interface label, else the configured default. Empty means any interface.
+func guestInterface(labels map[string]string, opts DiscoveryOptions) string {
+	if iface := labels[interfaceLabel]; iface != "" {
+		return iface
+	}
+	return opts.DefaultInterface
+}
+
+// selectIPs drops loopback and excluded addresses as well as addresses of other interfaces than the selected one,
+// then orders the remaining ones for the configured family: ipv4 (the default) and ipv6 only
+// keep their own family, dual keeps both preferring IPv4 and prefer-ipv6 keeps both preferring IPv6.
+// Addresses inside the preferred subnets are moved to the front.
+func selectIPs(ips []proxmox.IP, opts DiscoveryOptions, labels map[string]string) []proxmox.IP {
+	iface := guestInterface(labels, opts)
+
+	var ipv4, ipv6 []proxmox.IP
+	for _, ip := range ips {
+		if parsed := net.ParseIP(ip.Address); parsed == nil || parsed.IsLoopback() {
+			continue
+		}
+		if iface != "" && ip.Interface != iface {
+			continue
+		}
+		if cidrRank(ip, opts.ExcludedCIDRs) < len(opts.ExcludedCIDRs) {
+			continue
+		}
+		if isIPv6(ip) {
+			ipv6 = append(ipv6, ip)
+		} else {
+			ipv4 = append(ipv4, ip)
+		}
+	}
+
+	selected := make([]proxmox.IP, 0, len(ipv4)+len(ipv6))
+	switch opts.IPFamily {
+	case ipFamilyIPv6:
+		selected = append(selected, ipv6...)
+	case ipFamilyPreferIPv6:
+		selected = append(append(selected, ipv6...), ipv4...)
+	case ipFamilyDual:
+		selected = append(append(selected, ipv4...), ipv6...)
+	default:
+		selected = append(selected, ipv4...)
+	}
+
+	// Addresses inside a preferred subnet come first, in the order of the subnets.
+	sort.SliceStable(selected, func(i, j int) bool {
+		return cidrRank(selected[i], opts.PreferredCIDRs) < cidrRank(selected[j], opts.PreferredCIDRs)
+	})
+	return selected
+}
+
+// cidrRank returns the index of the first subnet containing the address, or len(cidrs) if none does.
+func cidrRank(ip proxmox.IP, cidrs []*net.IPNet) int {
+	parsed := net.ParseIP(ip.Address)
+	for i, cidr := range cidrs {
+		if cidr.Contains(parsed) {
+			return i
+		}
+	}
+	return len(cidrs)
+}
+
+// parseCIDRs parses a list of subnets such as 10.0.10.0/24 or 2001:db8::/64.
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	cidrs := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		_, cidr, err := net.ParseCIDR(strings.TrimSpace(value))
+		if err != nil {
+			return nil, err
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	return cidrs, nil
+}
@@ -0,0 +1,280 @@
+package provider
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NX211/traefik-proxmox-provider/proxmox"
+)
+
+const defaultNeighborTablePath = "/proc/net/arp"
+
+// Ways of handling the <name>.<node> hostname fallback of guests without a known IP.
+const (
+	hostnameFallbackUnchecked = "unchecked"
+	hostnameFallbackLog       = "log"
+	hostnameFallbackSkip      = "skip"
+)
+
+// lookupHost resolves hostnames, replaced in tests.
+var lookupHost = net.DefaultResolver.LookupHost
+
+// MACResolver finds the addresses currently bound to a MAC address, for guests whose addresses
+// can't be discovered through the guest agent or their configuration.
+type MACResolver interface {
+	LookupMAC(ctx context.Context, mac string) ([]proxmox.IP, error)
+}
+
+// fallbackIPs looks for the addresses of a guest the guest agent (or the container interfaces
+// endpoint) could not report, starting with the addresses statically set in its configuration
+// (container network devices or cloud-init settings), then asking the MAC resolvers.
+func fallbackIPs(ctx context.Context, config *proxmox.ParsedConfig, vmID uint64, opts DiscoveryOptions, labels map[string]string) []proxmox.IP {
+	if ips := selectIPs(config.GetStaticIPs(), opts, labels); len(ips) > 0 {
+		log.Printf("Using the statically configured IPs of guest %d", vmID)
+		return ips
+	}
+
+	if len(opts.MACResolvers) == 0 {
+		return nil
+	}
+
+	macs := config.GetMACAddresses()
+	for _, mac := range sortedStringKeys(macs) {
+		for _, resolver := range opts.MACResolvers {
+			ips, err := resolver.LookupMAC(ctx, mac)
+			if err != nil {
+				log.Printf("Error resolving MAC address %s of guest %d: %v", mac, vmID, err)
+				continue
+			}
+			for i := range ips {
+				ips[i].Interface = macs[mac]
+			}
+			if ips = selectIPs(ips, opts, labels); len(ips) > 0 {
+				log.Printf("Using the IPs bound to MAC address %s for guest %d", mac, vmID)
+				return ips
+			}
+		}
+	}
+	return nil
+}
+
+// checkHostnameFallbacks resolves the <name>.<node> hostname Traefik will be pointed at for the
+// services using it, see usesFallbackHostname. The hostnames are resolved concurrently, unresolvable
+// ones are logged, and their services dropped in skip mode.
+func checkHostnameFallbacks(ctx context.Context, services []proxmox.Service, nodeName string, opts DiscoveryOptions) []proxmox.Service {
+	if opts.HostnameFallback == hostnameFallbackUnchecked {
+		return services
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, maxConcurrentProbes)
+		failed = make(map[int]error)
+	)
+	for i, service := range services {
+		if !usesFallbackHostname(service, opts.Protocols) {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, hostname string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			lookupCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+			defer cancel()
+			if _, err := lookupHost(lookupCtx, hostname); err != nil {
+				mu.Lock()
+				failed[i] = err
+				mu.Unlock()
+			}
+		}(i, fallbackHostname(service, nodeName))
+	}
+	wg.Wait()
+	if len(failed) == 0 {
+		return services
+	}
+
+	checked := make([]proxmox.Service, 0, len(services))
+	for i, service := range services {
+		err, ok := failed[i]
+		if !ok {
+			checked = append(checked, service)
+			continue
+		}
+
+		hostname := fallbackHostname(service, nodeName)
+		if opts.HostnameFallback == hostnameFallbackSkip {
+			log.Printf("Skipping guest %s (%d) because it has no known IP and %s doesn't resolve: %v", service.Name, service.ID, hostname, err)
+			continue
+		}
+		log.Printf("WARNING: Guest %s (%d) has no known IP and its fallback hostname %s doesn't resolve: %v", service.Name, service.ID, hostname, err)
+		checked = append(checked, service)
+	}
+	return checked
+}
+
+// usesFallbackHostname reports whether Traefik is pointed at the <name>.<node> hostname of a guest: it
+// has no known IP, isn't reached through the NAT address of its node, and some of the servers generated for
+// it get their address neither from a url or address label nor from an IP label.
+func usesFallbackHostname(service proxmox.Service, protocols []string) bool {
+	if service.NodeAddress != "" || service.Config[ipLabel] != "" {
+		return false
+	}
+	for _, ip := range service.IPs {
+		if ip.Address != "" && ip.Address != "127.0.0.1" && ip.Address != "::1" {
+			return false
+		}
+	}
+
+	generated := ConfigurationOptions{Protocols: protocols}
+	for _, protocol := range []string{"http", "tcp", "udp"} {
+		if !generated.generates(protocol) || service.Config["traefik.proxmox."+protocol+".ip"] != "" {
+			continue
+		}
+		if hasAddressedServers(service.Config, protocol) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAddressedServers reports whether the builder fills in the address of a server of the protocol, as one
+// of its services (or the default HTTP service of the guest) has no url or address label.
+func hasAddressedServers(labels map[string]string, protocol string) bool {
+	services := getDefinedElements(labels, protocol, "services")
+	if len(services) == 0 {
+		// HTTP always gets a default service, TCP and UDP only along with their routers.
+		return protocol == "http" || len(getDefinedElements(labels, protocol, "routers")) > 0
+	}
+
+	field := "address"
+	if protocol == "http" {
+		field = "url"
+	}
+	for _, name := range services {
+		prefix := "traefik." + protocol + ".services." + name + "."
+		explicit := false
+		for key, value := range labels {
+			if !strings.HasPrefix(key, prefix) || value == "" {
+				continue
+			}
+			rest := strings.ToLower(key[len(prefix):])
+			// Weighted, mirroring and failover services forward to other services.
+			if !strings.HasPrefix(rest, "loadbalancer.") || rest == "loadbalancer.server."+field ||
+				(strings.HasPrefix(rest, "loadbalancer.servers[") && strings.HasSuffix(rest, "]."+field)) {
+				explicit = true
+				break
+			}
+		}
+		if !explicit {
+			return true
+		}
+	}
+	return false
+}
+
+// LocalNeighborTable resolves MAC addresses through the ARP table of the host running the provider, in the
+// /proc/net/arp format, not the one of the Proxmox nodes: the API doesn't expose their neighbor tables. It only
+// knows the guests on a L2 network shared with that host which it talked to recently.
+type LocalNeighborTable struct {
+	Path string
+}
+
+// LookupMAC returns the complete ARP entries of the MAC address.
+func (t *LocalNeighborTable) LookupMAC(_ context.Context, mac string) ([]proxmox.IP, error) {
+	path := t.Path
+	if path == "" {
+		path = defaultNeighborTablePath
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read neighbor table: %w", err)
+	}
+	defer file.Close()
+
+	var ips []proxmox.IP
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[2] == "0x0" || !strings.EqualFold(fields[3], mac) {
+			continue
+		}
+		ips = append(ips, proxmox.IP{Address: fields[0], AddressType: "ipv4"})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read neighbor table: %w", err)
+	}
+
+	sort.Slice(ips, func(i, j int) bool { return ips[i].Address < ips[j].Address })
+	return ips, nil
+}
+
+// pollResolver is implemented by the MAC resolvers that load their data once per poll.
+type pollResolver interface {
+	beginPoll()
+}
+
+// beginPoll starts a poll of the MAC resolvers, so they load their data again on the next lookup.
+func beginPoll(resolvers []MACResolver) {
+	for _, resolver := range resolvers {
+		if r, ok := resolver.(pollResolver); ok {
+			r.beginPoll()
+		}
+	}
+}
+
+// SDNIPAM resolves MAC addresses through the allocations of a Proxmox SDN IPAM. The status of the IPAM is
+// fetched once per poll, on the first lookup.
+type SDNIPAM struct {
+	Client *proxmox.ProxmoxClient
+	IPAM   string
+
+	mu      sync.Mutex
+	fetched bool
+	entries []proxmox.IPAMEntry
+	err     error
+}
+
+func (s *SDNIPAM) beginPoll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetched = false
+	s.entries = nil
+	s.err = nil
+}
+
+// LookupMAC returns the addresses the IPAM allocated to the MAC address.
+func (s *SDNIPAM) LookupMAC(ctx context.Context, mac string) ([]proxmox.IP, error) {
+	s.mu.Lock()
+	if !s.fetched {
+		s.entries, s.err = s.Client.GetSDNIPAMStatus(ctx, s.IPAM)
+		s.fetched = true
+	}
+	entries, err := s.entries, s.err
+	s.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status of IPAM %s: %w", s.IPAM, err)
+	}
+
+	var ips []proxmox.IP
+	for _, entry := range entries {
+		if entry.MAC == "" || !strings.EqualFold(entry.MAC, mac) {
+			continue
+		}
+		address, _, _ := strings.Cut(entry.IP, "/")
+		ips = append(ips, proxmox.IP{Address: address})
+	}
+	return ips, nil
+}
@@ -0,0 +1,430 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NX211/traefik-proxmox-provider/dynamic"
+)
+
+const defaultKVRootKey = "traefik"
+
+// kvStore is the minimal set of operations needed to publish a configuration to a KV store.
+type kvStore interface {
+	Put(ctx context.Context, key, value string) error
+	Delete(ctx context.Context, key string) error
+	// List returns the keys and values below a prefix.
+	List(ctx context.Context, prefix string) (map[string]string, error)
+}
+
+// errKVNotFound is returned for requests answered with 404, e.g. listing an empty prefix in Consul.
+var errKVNotFound = errors.New("not found")
+
+// kvOutput publishes the configuration to a KV store using Traefik's KV key layout,
+// e.g. traefik/http/routers/<name>/rule.
+type kvOutput struct {
+	store   kvStore
+	rootKey string
+
+	// written holds the keys and values in the store below the root key, listed on the first write.
+	written map[string]string
+}
+
+func newKVOutput(endpoint, rootKey string) (*kvOutput, error) {
+	store, err := newKVStore(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if rootKey == "" {
+		rootKey = defaultKVRootKey
+	}
+	return &kvOutput{store: store, rootKey: strings.Trim(rootKey, "/")}, nil
+}
+
+// newKVStore creates a store client from an endpoint URL such as consul://127.0.0.1:8500,
+// etcd://127.0.0.1:2379 or redis://:password@127.0.0.1:6379/0.
+func newKVStore(endpoint string) (kvStore, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid KV endpoint: %w", err)
+	}
+
+	password, _ := u.User.Password()
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+
+	switch u.Scheme {
+	case "consul", "consul+https":
+		return &consulStore{baseURL: httpBaseURL(u), token: password, client: httpClient}, nil
+	case "etcd", "etcd+https":
+		return &etcdStore{baseURL: httpBaseURL(u), client: httpClient}, nil
+	case "redis":
+		db := 0
+		if path := strings.Trim(u.Path, "/"); path != "" {
+			db, err = strconv.Atoi(path)
+			if err != nil {
+				return nil, fmt.Errorf("invalid redis database %q", path)
+			}
+		}
+		return &redisStore{address: u.Host, password: password, db: db}, nil
+	default:
+		return nil, fmt.Errorf("unsupported KV store %q, expected consul, etcd or redis", u.Scheme)
+	}
+}
+
+func httpBaseURL(u *url.URL) string {
+	scheme := "http"
+	if strings.HasSuffix(u.Scheme, "+https") {
+		scheme = "https"
+	}
+	return scheme + "://" + u.Host
+}
+
+// Write publishes the changed keys and removes the keys that are no longer part of the configuration.
+func (o *kvOutput) Write(ctx context.Context, configuration *dynamic.Configuration) error {
+	pairs, err := flattenKV(o.rootKey, configuration)
+	if err != nil {
+		return err
+	}
+
+	if o.written == nil {
+		// Start from what a previous run left behind, so the instances reading the store keep the routers
+		// that didn't change instead of seeing the tree cleared and rebuilt.
+		existing, err := o.store.List(ctx, o.rootKey+"/")
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %w", o.rootKey, err)
+		}
+		o.written = existing
+	}
+
+	for _, key := range sortedStringKeys(pairs) {
+		if previous, ok := o.written[key]; ok && previous == pairs[key] {
+			continue
+		}
+		if err := o.store.Put(ctx, key, pairs[key]); err != nil {
+			return fmt.Errorf("failed to put %s: %w", key, err)
+		}
+		o.written[key] = pairs[key]
+	}
+
+	for _, key := range sortedStringKeys(o.written) {
+		if _, ok := pairs[key]; ok {
+			continue
+		}
+		if err := o.store.Delete(ctx, key); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", key, err)
+		}
+		delete(o.written, key)
+	}
+	return nil
+}
+
+// flattenKV converts a configuration into KV pairs. List items use their index as key
+// and empty sections (e.g. tls: {}) are published with the value "true".
+func flattenKV(rootKey string, configuration *dynamic.Configuration) (map[string]string, error) {
+	data, err := json.Marshal(configuration)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal configuration: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var generic interface{}
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, fmt.Errorf("failed to decode configuration: %w", err)
+	}
+
+	pairs := make(map[string]string)
+	flattenKVValue(pairs, rootKey, generic)
+	return pairs, nil
+}
+
+func flattenKVValue(pairs map[string]string, key string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			pairs[key] = "true"
+			return
+		}
+		for k, child := range v {
+			flattenKVValue(pairs, key+"/"+k, child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			flattenKVValue(pairs, key+"/"+strconv.Itoa(i), child)
+		}
+	case nil:
+	case string:
+		pairs[key] = v
+	default:
+		pairs[key] = fmt.Sprintf("%v", v)
+	}
+}
+
+func sortedStringKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// consulStore talks to the Consul KV HTTP API.
+type consulStore struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+func (s *consulStore) Put(ctx context.Context, key, value string) error {
+	return s.do(ctx, http.MethodPut, "/v1/kv/"+key, strings.NewReader(value), nil)
+}
+
+func (s *consulStore) Delete(ctx context.Context, key string) error {
+	return s.do(ctx, http.MethodDelete, "/v1/kv/"+key, nil, nil)
+}
+
+func (s *consulStore) List(ctx context.Context, prefix string) (map[string]string, error) {
+	var entries []struct {
+		Key   string
+		Value []byte
+	}
+	err := s.do(ctx, http.MethodGet, "/v1/kv/"+prefix+"?recurse=true", nil, &entries)
+	if err != nil && !errors.Is(err, errKVNotFound) {
+		return nil, err
+	}
+
+	pairs := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		pairs[entry.Key] = string(entry.Value)
+	}
+	return pairs, nil
+}
+
+func (s *consulStore) do(ctx context.Context, method, path string, body io.Reader, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, s.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if s.token != "" {
+		req.Header.Set("X-Consul-Token", s.token)
+	}
+	return doKVRequest(s.client, req, result)
+}
+
+// etcdStore talks to the etcd v3 JSON gateway.
+type etcdStore struct {
+	baseURL string
+	client  *http.Client
+}
+
+func (s *etcdStore) Put(ctx context.Context, key, value string) error {
+	return s.post(ctx, "/v3/kv/put", map[string]string{"key": b64(key), "value": b64(value)}, nil)
+}
+
+func (s *etcdStore) Delete(ctx context.Context, key string) error {
+	return s.post(ctx, "/v3/kv/deleterange", map[string]string{"key": b64(key)}, nil)
+}
+
+func (s *etcdStore) List(ctx context.Context, prefix string) (map[string]string, error) {
+	// The range end of a prefix is the prefix with its last byte incremented.
+	end := []byte(prefix)
+	end[len(end)-1]++
+	var response struct {
+		KVs []struct {
+			Key   []byte `json:"key"`
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := s.post(ctx, "/v3/kv/range", map[string]string{"key": b64(prefix), "range_end": b64(string(end))}, &response); err != nil {
+		return nil, err
+	}
+
+	pairs := make(map[string]string, len(response.KVs))
+	for _, kv := range response.KVs {
+		pairs[string(kv.Key)] = string(kv.Value)
+	}
+	return pairs, nil
+}
+
+func (s *etcdStore) post(ctx context.Context, path string, payload map[string]string, result interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doKVRequest(s.client, req, result)
+}
+
+func b64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+// doKVRequest sends a request, decoding the JSON answer into the result when one is given.
+func doKVRequest(client *http.Client, req *http.Request, result interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errKVNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+	if result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return nil
+}
+
+// redisStore speaks the Redis protocol (RESP) over a short-lived connection per command.
+type redisStore struct {
+	address  string
+	password string
+	db       int
+}
+
+func (s *redisStore) Put(ctx context.Context, key, value string) error {
+	_, err := s.run(ctx, []string{"SET", key, value})
+	return err
+}
+
+func (s *redisStore) Delete(ctx context.Context, key string) error {
+	_, err := s.run(ctx, []string{"DEL", key})
+	return err
+}
+
+func (s *redisStore) List(ctx context.Context, prefix string) (map[string]string, error) {
+	// EVAL keeps the SCAN/GET loop server side, returning the keys and values in turn.
+	script := `local pairs = {}
+local cursor = "0"
+repeat
+  local result = redis.call("SCAN", cursor, "MATCH", ARGV[1], "COUNT", 1000)
+  cursor = result[1]
+  for _, key in ipairs(result[2]) do
+    if redis.call("TYPE", key).ok == "string" then
+      table.insert(pairs, key)
+      table.insert(pairs, redis.call("GET", key))
+    end
+  end
+until cursor == "0"
+return pairs`
+	reply, err := s.run(ctx, []string{"EVAL", script, "0", prefix + "*"})
+	if err != nil {
+		return nil, err
+	}
+
+	items, _ := reply.([]interface{})
+	pairs := make(map[string]string, len(items)/2)
+	for i := 0; i+1 < len(items); i += 2 {
+		key, _ := items[i].(string)
+		value, _ := items[i+1].(string)
+		pairs[key] = value
+	}
+	return pairs, nil
+}
+
+// run sends a command and returns its reply.
+func (s *redisStore) run(ctx context.Context, command []string) (interface{}, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	var commands [][]string
+	if s.password != "" {
+		commands = append(commands, []string{"AUTH", s.password})
+	}
+	if s.db != 0 {
+		commands = append(commands, []string{"SELECT", strconv.Itoa(s.db)})
+	}
+	commands = append(commands, command)
+
+	var reply interface{}
+	for _, cmd := range commands {
+		if _, err := conn.Write(encodeRESP(cmd)); err != nil {
+			return nil, err
+		}
+		if reply, err = readRESPReply(reader); err != nil {
+			return nil, fmt.Errorf("%s: %w", cmd[0], err)
+		}
+	}
+	return reply, nil
+}
+
+func encodeRESP(args []string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return b.Bytes()
+}
+
+// readRESPReply consumes a single reply and returns an error for Redis error replies. Bulk strings are
+// returned as strings, arrays as []interface{} and nil values as nil.
+func readRESPReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty reply")
+	}
+
+	switch line[0] {
+	case '-':
+		return nil, fmt.Errorf("redis error: %s", line[1:])
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = readRESPReply(reader); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return line[1:], nil
+}
@@ -0,0 +1,537 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/NX211/traefik-proxmox-provider/dynamic/tls"
+	"github.com/NX211/traefik-proxmox-provider/proxmox"
+)
+
+const (
+	defaultGuestLabelFile = "/etc/traefik/labels"
+
+	// proxmoxLabelPrefix starts the labels read by the provider itself rather than decoded into the configuration.
+	proxmoxLabelPrefix = "traefik.proxmox."
+
+	// configRefLabel references a snippet holding the configuration of a guest, e.g. local:snippets/vm105.yaml.
+	configRefLabel = "traefik.proxmox.configRef"
+
+	// tlsCertificateLabel and tlsKeyLabel reference the snippets holding the PEM certificate and key of a guest,
+	// tlsStoresLabel lists the TLS stores the certificate is added to.
+	tlsCertificateLabel = "traefik.proxmox.tls.certificate"
+	tlsKeyLabel         = "traefik.proxmox.tls.key"
+	tlsStoresLabel      = "traefik.proxmox.tls.stores"
+
+	// groupLabel puts a guest in a replica group: its default router and service are shared with the
+	// other members of the group, and the servers of its HTTP, TCP and UDP services are merged with theirs.
+	groupLabel = "traefik.proxmox.group"
+
+	// weightLabel sets the share of the traffic a member of a replica group receives. Groups with a weighted
+	// member keep a service per member behind a weighted round robin service, e.g. for canary releases.
+	weightLabel = "traefik.proxmox.weight"
+
+	// maintenanceLabel puts a guest in maintenance: its HTTP routers answer 503 Service Unavailable,
+	// or the maintenance page, and the servers of a replica group member are drained.
+	maintenanceLabel = "traefik.proxmox.maintenance"
+
+	// serviceNameLabel names the default router and service of a guest instead of <name>-<vmid>, so the
+	// name stays the same when the guest is recreated with another VMID.
+	serviceNameLabel = "traefik.proxmox.serviceName"
+
+	// defaultMiddlewaresLabel set to false keeps the defaultMiddlewares option off the routers of a guest.
+	defaultMiddlewaresLabel = "traefik.proxmox.defaultMiddlewares"
+
+	// failoverForLabel makes the HTTP service of a guest the fallback of the named primary service.
+	failoverForLabel = "traefik.proxmox.failoverFor"
+)
+
+// guestLabels collects the labels of a guest from its notes and tags, its label file and
+// the snippet referenced by its configRef label, on top of its default labels.
+func (f *guestFilter) guestLabels(client *proxmox.ProxmoxClient, ctx context.Context, guest guestRef, config *proxmox.ParsedConfig) map[string]string {
+	labels := config.GetTraefikMap()
+	// A missing label file says nothing about the agent, the failures of the read aren't recorded.
+	if f.opts.GuestLabelFile != "" && !guest.IsContainer && guest.Status == "running" && f.opts.AgentBackoff.allows(guest.VMID, guest.Uptime) {
+		labels = withGuestFileLabels(client, ctx, guest.Node, guest.VMID, f.opts.GuestLabelFile, labels)
+	}
+	if ref := labels[configRefLabel]; ref != "" {
+		labels = f.withSnippetLabels(client, ctx, guest.VMID, ref, labels)
+	}
+	defaults := f.defaultLabels(guest)
+	// The group and service name may come from the defaults, e.g. from the labels of a pool.
+	ids := make(map[string]string, 2)
+	for _, label := range []string{groupLabel, serviceNameLabel} {
+		if value := labels[label]; value != "" {
+			ids[label] = value
+		} else if value := defaults[label]; value != "" {
+			ids[label] = value
+		}
+	}
+	defaultID := serviceID(guest.Name, guest.VMID, ids)
+	// Shorthands of the guest are expanded before the defaults are merged, so they take precedence
+	// over default labels, then once more for shorthands set as defaults.
+	labels = withShorthandLabels(labels, defaultID)
+	labels = withShorthandLabels(withDefaultLabels(labels, defaults, defaultID), defaultID)
+	// The TCP shorthands share their prefix with the labels decoded into the configuration, so they're
+	// dropped once expanded.
+	for _, key := range tcpShorthandLabels {
+		delete(labels, key)
+	}
+	return labels
+}
+
+// tcpShorthandLabels are the shorthands for TCP routers and services.
+var tcpShorthandLabels = []string{"traefik.tcp.sni", "traefik.tcp.port", "traefik.tcp.ports", "traefik.tcp.passthrough"}
+
+// maxPortRange bounds the number of routers and services a traefik.tcp.ports label expands to.
+const maxPortRange = 1000
+
+// withShorthandLabels expands traefik.port, traefik.scheme and traefik.host to the server port and scheme
+// of every HTTP service and the Host rule of every HTTP router of the guest, unless they are set already.
+// traefik.tcp.sni and traefik.tcp.port do the same for the HostSNI rule and server port of TCP routers and
+// services. traefik.host and traefik.tcp.sni may list several hosts separated by commas. traefik.grpc=true
+// sets the h2c scheme for gRPC servers, unless traefik.scheme is set. traefik.tcp.ports expands to a TCP
+// router and service per port, see portRangeLabels.
+func withShorthandLabels(labels map[string]string, defaultID string) map[string]string {
+	// Port ranges are expanded first, so the other TCP shorthands apply to their routers and services.
+	if ports := labels["traefik.tcp.ports"]; ports != "" {
+		labels = withDefaultLabels(labels, portRangeLabels(ports, defaultID, labels["traefik.tcp.sni"] == ""), defaultID)
+	}
+
+	shorthands := make(map[string]string)
+	if port := labels["traefik.port"]; port != "" {
+		shorthands["traefik.http.services.*.loadbalancer.server.port"] = port
+	}
+	if scheme := labels["traefik.scheme"]; scheme != "" {
+		shorthands["traefik.http.services.*.loadbalancer.server.scheme"] = scheme
+	} else if labels["traefik.grpc"] == "true" {
+		shorthands["traefik.http.services.*.loadbalancer.server.scheme"] = "h2c"
+	}
+	if hosts := labels["traefik.host"]; hosts != "" {
+		var rules []string
+		for _, host := range strings.Split(hosts, ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				rules = append(rules, fmt.Sprintf("Host(`%s`)", host))
+			}
+		}
+		shorthands["traefik.http.routers.*.rule"] = strings.Join(rules, " || ")
+	}
+	if hosts := labels["traefik.tcp.sni"]; hosts != "" {
+		var rules []string
+		for _, host := range strings.Split(hosts, ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				rules = append(rules, fmt.Sprintf("HostSNI(`%s`)", host))
+			}
+		}
+		shorthands[tcpShorthandKey(labels, "routers", defaultID, "rule")] = strings.Join(rules, " || ")
+		// A SNI rule needs TLS on the router, passthrough forwards it to the guest untouched.
+		passthrough := labels["traefik.tcp.passthrough"]
+		if passthrough == "" {
+			passthrough = "true"
+		}
+		shorthands[tcpShorthandKey(labels, "routers", defaultID, "tls.passthrough")] = passthrough
+	}
+	if port := labels["traefik.tcp.port"]; port != "" {
+		shorthands[tcpShorthandKey(labels, "services", defaultID, "loadbalancer.server.port")] = port
+	}
+	return withDefaultLabels(labels, shorthands, defaultID)
+}
+
+// portRangeLabels returns the labels of a TCP router and service named <defaultID>-<port> for every port of a
+// list of ports and port ranges such as 25565-25570 or 25,465,587, e.g. for game servers. The routers match
+// any connection, unless rule is false, so each port needs its own entry point: the one mapped to the port
+// in portEntryPoints, else tcp-<port>, see addPortRangeEntryPoints.
+func portRangeLabels(ports, defaultID string, rule bool) map[string]string {
+	var expanded []int
+	for _, item := range strings.Split(ports, ",") {
+		item = strings.TrimSpace(item)
+		first, last, isRange := strings.Cut(item, "-")
+		if !isRange {
+			last = first
+		}
+		from, err := strconv.Atoi(strings.TrimSpace(first))
+		if err != nil {
+			log.Printf("Ignoring invalid port range %q", ports)
+			return nil
+		}
+		to, err := strconv.Atoi(strings.TrimSpace(last))
+		if err != nil || from < 1 || to > 65535 || from > to {
+			log.Printf("Ignoring invalid port range %q", ports)
+			return nil
+		}
+		if len(expanded)+to-from >= maxPortRange {
+			log.Printf("Ignoring port range %q, it expands to more than %d ports", ports, maxPortRange)
+			return nil
+		}
+		for port := from; port <= to; port++ {
+			expanded = append(expanded, port)
+		}
+	}
+
+	labels := make(map[string]string, 3*len(expanded))
+	for _, port := range expanded {
+		name := fmt.Sprintf("%s-%d", defaultID, port)
+		if rule {
+			labels["traefik.tcp.routers."+name+".rule"] = "HostSNI(`*`)"
+		}
+		labels["traefik.tcp.routers."+name+".service"] = name
+		labels["traefik.tcp.services."+name+".loadbalancer.server.port"] = strconv.Itoa(port)
+	}
+	return labels
+}
+
+// tcpShorthandKey returns the label a TCP shorthand expands to: a wildcard over the TCP routers or services
+// of the guest, or its default router or service when it defines none, as TCP has no default router otherwise.
+func tcpShorthandKey(labels map[string]string, elemType, defaultID, suffix string) string {
+	name := "*"
+	if len(getDefinedElements(labels, "tcp", elemType)) == 0 {
+		name = defaultID
+	}
+	return fmt.Sprintf("traefik.tcp.%s.%s.%s", elemType, name, suffix)
+}
+
+// defaultLabels returns the default labels of a guest: the provider defaults, overridden by the
+// labels of its node, the labels of its pool comment and the configured labels of its pool.
+func (f *guestFilter) defaultLabels(guest guestRef) map[string]string {
+	pool := f.pools[guest.VMID]
+	defaults := make(map[string]string)
+	for _, labels := range []map[string]string{f.opts.Defaults, f.opts.NodeLabels[guest.Node], f.poolCommentLabels[pool], f.opts.PoolLabels[pool]} {
+		for key, value := range labels {
+			defaults[key] = value
+		}
+	}
+	return defaults
+}
+
+// withDefaultLabels merges default labels beneath the labels of a guest. A "*" in place of a router
+// or service name, e.g. traefik.http.routers.*.entrypoints, applies the label to each router or service
+// of the guest. defaultID is the name of the default HTTP router and service of the guest.
+func withDefaultLabels(labels, defaults map[string]string, defaultID string) map[string]string {
+	if len(defaults) == 0 {
+		return labels
+	}
+
+	merged := make(map[string]string, len(labels)+len(defaults))
+	var wildcards []string
+	for key, value := range defaults {
+		if strings.Contains(key, ".*.") {
+			wildcards = append(wildcards, key)
+			continue
+		}
+		merged[key] = value
+	}
+	for key, value := range labels {
+		merged[key] = value
+	}
+
+	expanded := make(map[string]string)
+	for _, key := range wildcards {
+		for _, name := range expandWildcardLabel(key, merged, defaultID) {
+			if _, ok := merged[name]; !ok {
+				expanded[name] = defaults[key]
+			}
+		}
+	}
+	for key, value := range expanded {
+		merged[key] = value
+	}
+	return merged
+}
+
+// expandWildcardLabel returns the labels a wildcard label stands for: one per router or service
+// defined in labels, or the default HTTP router or service when none is.
+func expandWildcardLabel(key string, labels map[string]string, defaultID string) []string {
+	for _, proto := range []string{"http", "tcp", "udp"} {
+		for _, elemType := range []string{"routers", "services"} {
+			prefix := fmt.Sprintf("traefik.%s.%s.*.", proto, elemType)
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+
+			names := getDefinedElements(labels, proto, elemType)
+			if len(names) == 0 && proto == "http" {
+				names = []string{defaultID}
+			}
+			keys := make([]string, 0, len(names))
+			for _, name := range names {
+				keys = append(keys, fmt.Sprintf("traefik.%s.%s.%s.%s", proto, elemType, name, key[len(prefix):]))
+			}
+			return keys
+		}
+	}
+	log.Printf("Warning: ignoring default label %s, \"*\" can only replace router and service names", key)
+	return nil
+}
+
+// serverAddressLabel matches the labels setting the address of a server, e.g.
+// traefik.http.services.web.loadbalancer.server.url or traefik.tcp.services.db.loadbalancer.servers[0].address.
+var serverAddressLabel = regexp.MustCompile(`(?i)^traefik\.(http|tcp|udp)\.services\.[^.]+\.loadbalancer\.(server|servers\[\d+\])\.(url|address)$`)
+
+// withGuestFileLabels adds the labels of the label file inside a VM, read through the guest agent.
+// Labels set in the notes take precedence, so Proxmox administrators keep the last word.
+func withGuestFileLabels(client *proxmox.ProxmoxClient, ctx context.Context, nodeName string, vmID uint64, path string, labels map[string]string) map[string]string {
+	content, err := client.ReadVMFile(ctx, nodeName, vmID, path)
+	if err != nil {
+		if client.LogLevel == proxmox.LogLevelDebug {
+			log.Printf("DEBUG: Could not read label file %s of VM %d: %v", path, vmID, err)
+		}
+		return labels
+	}
+
+	merged := proxmox.ParseLabels(content)
+	// The file is written from inside the guest: the provider labels would let it read any snippet of the
+	// host through configRef or the TLS labels, and they, the server addresses and the configuration
+	// document would let it send traffic to any backend, so they're ignored.
+	for key := range merged {
+		lower := strings.ToLower(key)
+		if strings.HasPrefix(lower, proxmoxLabelPrefix) || lower == proxmox.ConfigDocumentLabel || serverAddressLabel.MatchString(key) {
+			log.Printf("WARNING: Ignoring label %s of the label file of VM %d, it can only be set in the notes", key, vmID)
+			delete(merged, key)
+		}
+	}
+	for key, value := range labels {
+		merged[key] = value
+	}
+	return merged
+}
+
+// publishedPortsCommand lists the listening TCP sockets of a VM, with netstat where ss is missing.
+var publishedPortsCommand = []string{"sh", "-c", "ss -Htln 2>/dev/null || netstat -tln"}
+
+// publishTimeout bounds the time spent listing the listening ports of a VM.
+const publishTimeout = 10 * time.Second
+
+// withPublishedPorts adds a TCP router and service for every port a running VM listens on, like the
+// publish-all mode of Docker, found by running ss or netstat through the guest agent. Ports only bound to a
+// loopback address and ports already used by a server label are skipped.
+func withPublishedPorts(client *proxmox.ProxmoxClient, ctx context.Context, nodeName string, vmID uint64, defaultID string, labels map[string]string) map[string]string {
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+	output, err := client.ExecVMCommand(ctx, nodeName, vmID, publishedPortsCommand)
+	if err != nil {
+		log.Printf("Error listing the listening ports of VM %d: %v", vmID, err)
+		return labels
+	}
+
+	used := make(map[string]bool)
+	for key, value := range labels {
+		if key == "traefik.port" || strings.HasSuffix(strings.ToLower(key), "loadbalancer.server.port") {
+			used[value] = true
+		}
+	}
+	var ports []string
+	for _, port := range listeningPorts(output) {
+		if !used[port] {
+			ports = append(ports, port)
+		}
+	}
+	if len(ports) == 0 {
+		return labels
+	}
+	return withDefaultLabels(labels, portRangeLabels(strings.Join(ports, ","), defaultID, true), defaultID)
+}
+
+// listeningPorts returns the sorted ports of the sockets listed by ss -Htln or netstat -tln that listen on
+// an address other than loopback.
+func listeningPorts(output string) []string {
+	found := make(map[int]bool)
+	for _, line := range strings.Split(output, "\n") {
+		// The local address is the fourth column of both commands, header lines don't have one.
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		local := fields[3]
+		i := strings.LastIndex(local, ":")
+		if i < 0 {
+			continue
+		}
+		port, err := strconv.Atoi(local[i+1:])
+		if err != nil {
+			continue
+		}
+		host := strings.Trim(local[:i], "[]")
+		if zone := strings.Index(host, "%"); zone >= 0 {
+			host = host[:zone]
+		}
+		if ip := net.ParseIP(host); (ip != nil && ip.IsLoopback()) || host == "localhost" {
+			continue
+		}
+		found[port] = true
+	}
+
+	sorted := make([]int, 0, len(found))
+	for port := range found {
+		sorted = append(sorted, port)
+	}
+	sort.Ints(sorted)
+	ports := make([]string, len(sorted))
+	for i, port := range sorted {
+		ports[i] = strconv.Itoa(port)
+	}
+	return ports
+}
+
+// withSnippetLabels adds the configuration of a snippet, written in the layout of Traefik's
+// dynamic configuration. Labels of the guest take precedence over the snippet.
+func (f *guestFilter) withSnippetLabels(client *proxmox.ProxmoxClient, ctx context.Context, vmID uint64, ref string, labels map[string]string) map[string]string {
+	content, err := f.readSnippet(client, ctx, ref)
+	if err != nil {
+		log.Printf("Error reading snippet %s of guest %d: %v", ref, vmID, err)
+		return labels
+	}
+
+	merged, err := proxmox.ParseConfigBlock(string(content))
+	if err != nil {
+		log.Printf("Error parsing snippet %s of guest %d: %v", ref, vmID, err)
+		return labels
+	}
+	for key, value := range labels {
+		merged[key] = value
+	}
+	return merged
+}
+
+// guestCertificates loads the certificate and key referenced by the traefik.proxmox.tls.certificate and
+// traefik.proxmox.tls.key labels from snippet storage, so a guest can ship its own certificate.
+func (f *guestFilter) guestCertificates(client *proxmox.ProxmoxClient, ctx context.Context, vmID uint64, labels map[string]string) []*tls.CertAndStores {
+	certRef, keyRef := labels[tlsCertificateLabel], labels[tlsKeyLabel]
+	if certRef == "" && keyRef == "" {
+		return nil
+	}
+	if certRef == "" || keyRef == "" {
+		log.Printf("Ignoring the certificate of guest %d: both %s and %s must be set", vmID, tlsCertificateLabel, tlsKeyLabel)
+		return nil
+	}
+
+	cert, err := f.readPEMSnippet(client, ctx, certRef)
+	if err != nil {
+		log.Printf("Error reading certificate %s of guest %d: %v", certRef, vmID, err)
+		return nil
+	}
+	key, err := f.readPEMSnippet(client, ctx, keyRef)
+	if err != nil {
+		log.Printf("Error reading key %s of guest %d: %v", keyRef, vmID, err)
+		return nil
+	}
+
+	// Traefik accepts the PEM content in place of a file path.
+	certificate := &tls.CertAndStores{Certificate: tls.Certificate{CertFile: cert, KeyFile: key}}
+	for _, store := range strings.Split(labels[tlsStoresLabel], ",") {
+		if store = strings.TrimSpace(store); store != "" {
+			certificate.Stores = append(certificate.Stores, store)
+		}
+	}
+	return []*tls.CertAndStores{certificate}
+}
+
+func (f *guestFilter) readPEMSnippet(client *proxmox.ProxmoxClient, ctx context.Context, ref string) (string, error) {
+	content, err := f.readSnippet(client, ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	if !strings.Contains(string(content), "-----BEGIN ") {
+		return "", fmt.Errorf("not PEM encoded")
+	}
+	return string(content), nil
+}
+
+// readSnippet returns the content of a snippet volume such as local:snippets/vm105.yaml.
+func (f *guestFilter) readSnippet(client *proxmox.ProxmoxClient, ctx context.Context, ref string) ([]byte, error) {
+	path, err := f.snippetPath(client, ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// snippetPath returns the local path of a snippet volume such as local:snippets/vm105.yaml.
+// The Proxmox API can't download snippets, so the storage must be readable by the provider:
+// either at the path configured in snippetPaths or at the path of the storage itself.
+func (f *guestFilter) snippetPath(client *proxmox.ProxmoxClient, ctx context.Context, ref string) (string, error) {
+	storage, volume, found := strings.Cut(ref, ":")
+	name := strings.TrimPrefix(volume, "snippets/")
+	if !found || storage == "" || name == volume || name == "" || strings.Contains(name, "/") || name == ".." {
+		return "", fmt.Errorf("expected <storage>:snippets/<file>")
+	}
+
+	if dir, ok := f.opts.SnippetPaths[storage]; ok {
+		return filepath.Join(dir, "snippets", name), nil
+	}
+
+	dir, ok := f.storagePaths[storage]
+	if !ok {
+		config, err := client.GetStorage(ctx, storage)
+		if err != nil {
+			return "", fmt.Errorf("error getting storage %s: %w", storage, err)
+		}
+		if config.Path == "" {
+			return "", fmt.Errorf("storage %s has no local path, set it in snippetPaths", storage)
+		}
+		dir = config.Path
+		if f.storagePaths == nil {
+			f.storagePaths = make(map[string]string)
+		}
+		f.storagePaths[storage] = dir
+	}
+	return filepath.Join(dir, "snippets", name), nil
+}
+
+// parseLabelMap parses configured label lines, written as in the notes, for each key.
+func parseLabelMap(text map[string]string) map[string]map[string]string {
+	if len(text) == 0 {
+		return nil
+	}
+	labels := make(map[string]map[string]string, len(text))
+	for key, value := range text {
+		labels[key] = proxmox.ParseLabels(value)
+	}
+	return labels
+}
+
+// expandLabelTemplates expands the template variables of label values, e.g. Host(`{{ .Name }}.example.com`).
+// The variables are .Name, .VMID, .Node, .IP, .Host (the host of the default Host rule) and .Domain. Values that fail to expand are kept as is.
+func expandLabelTemplates(service proxmox.Service, nodeName string) map[string]string {
+	var data map[string]interface{}
+	labels := make(map[string]string, len(service.Config))
+	for key, value := range service.Config {
+		labels[key] = value
+		if !strings.Contains(value, "{{") {
+			continue
+		}
+
+		if data == nil {
+			data = map[string]interface{}{
+				"Name":   service.Name,
+				"VMID":   service.ID,
+				"Node":   nodeName,
+				"IP":     getServiceIP(service, nodeName, "http"),
+				"Host":   defaultHost(service),
+				"Domain": service.Domain,
+			}
+		}
+
+		tmpl, err := template.New(key).Option("missingkey=error").Parse(value)
+		if err != nil {
+			log.Printf("Error parsing template of label %s for service %s: %v", key, service.Name, err)
+			continue
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			log.Printf("Error expanding template of label %s for service %s: %v", key, service.Name, err)
+			continue
+		}
+		labels[key] = b.String()
+	}
+	return labels
+}
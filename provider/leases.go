@@ -0,0 +1,131 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/NX211/traefik-proxmox-provider/proxmox"
+)
+
+// newLeaseResolver creates a DHCP lease source from an endpoint such as kea+http://127.0.0.1:8000. Lease
+// files are read from the host running the provider, they're set with localDnsmasqLeases instead.
+func newLeaseResolver(endpoint string) (MACResolver, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DHCP lease source: %w", err)
+	}
+
+	switch u.Scheme {
+	case "kea+http", "kea+https":
+		return &KeaLeases{
+			URL:    strings.TrimPrefix(u.Scheme, "kea+") + "://" + u.Host + u.Path,
+			Client: &http.Client{Timeout: 10 * time.Second},
+		}, nil
+	case "file":
+		return nil, fmt.Errorf("lease files are read from the host running the provider, set localDnsmasqLeases to %s", u.Path)
+	default:
+		return nil, fmt.Errorf("unsupported DHCP lease source %q, expected kea+http", u.Scheme)
+	}
+}
+
+// DnsmasqLeases resolves MAC addresses through a dnsmasq lease file on the host running the provider. The
+// Proxmox API doesn't serve the lease files of the nodes, they have to be mounted or copied to that host.
+type DnsmasqLeases struct {
+	Path string
+}
+
+// LookupMAC returns the addresses leased to the MAC address.
+func (l *DnsmasqLeases) LookupMAC(_ context.Context, mac string) ([]proxmox.IP, error) {
+	file, err := os.Open(l.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lease file: %w", err)
+	}
+	defer file.Close()
+
+	var ips []proxmox.IP
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// expiry, MAC address, IP address, hostname, client ID
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || !strings.EqualFold(fields[1], mac) {
+			continue
+		}
+		ips = append(ips, proxmox.IP{Address: fields[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read lease file: %w", err)
+	}
+	return ips, nil
+}
+
+// KeaLeases resolves MAC addresses through the Kea Control Agent API.
+type KeaLeases struct {
+	URL    string
+	Client *http.Client
+}
+
+// LookupMAC returns the DHCPv4 leases of the MAC address.
+func (l *KeaLeases) LookupMAC(ctx context.Context, mac string) ([]proxmox.IP, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"command":   "lease4-get-by-hw-address",
+		"service":   []string{"dhcp4"},
+		"arguments": map[string]string{"hw-address": mac},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := l.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var results []struct {
+		Result    int    `json:"result"`
+		Text      string `json:"text"`
+		Arguments struct {
+			Leases []struct {
+				IPAddress string `json:"ip-address"`
+			} `json:"leases"`
+		} `json:"arguments"`
+	}
+	if err := json.Unmarshal(respBody, &results); err != nil {
+		return nil, fmt.Errorf("failed to decode Kea response: %w", err)
+	}
+
+	var ips []proxmox.IP
+	for _, result := range results {
+		// 0 is success, 3 means no lease was found.
+		if result.Result != 0 && result.Result != 3 {
+			return nil, fmt.Errorf("kea error: %s", result.Text)
+		}
+		for _, lease := range result.Arguments.Leases {
+			ips = append(ips, proxmox.IP{Address: lease.IPAddress, AddressType: "ipv4"})
+		}
+	}
+	return ips, nil
+}
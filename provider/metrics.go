@@ -0,0 +1,162 @@
+package provider
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NX211/traefik-proxmox-provider/dynamic"
+)
+
+// pollDurationBuckets are the upper bounds (in seconds) of the poll duration histogram.
+var pollDurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// providerMetrics collects counters and histograms about the provider internals
+// and renders them in the Prometheus text exposition format.
+type providerMetrics struct {
+	mu sync.Mutex
+
+	pollCount        uint64
+	pollSum          float64
+	pollBuckets      []uint64
+	pollErrors       uint64
+	apiErrors        map[string]int
+	guestsDiscovered map[string]int
+	routersGenerated map[string]int
+	configPushes     uint64
+}
+
+func newProviderMetrics() *providerMetrics {
+	return &providerMetrics{
+		pollBuckets:      make([]uint64, len(pollDurationBuckets)),
+		apiErrors:        make(map[string]int),
+		guestsDiscovered: make(map[string]int),
+		routersGenerated: make(map[string]int),
+	}
+}
+
+// observePoll records the duration and outcome of a single discovery pass.
+func (m *providerMetrics) observePoll(duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seconds := duration.Seconds()
+	m.pollCount++
+	m.pollSum += seconds
+	for i, bound := range pollDurationBuckets {
+		if seconds <= bound {
+			m.pollBuckets[i]++
+		}
+	}
+	if err != nil {
+		m.pollErrors++
+	}
+}
+
+// observeAPIRequest is used as the client request observer and counts failed API calls per node.
+func (m *providerMetrics) observeAPIRequest(_ string, path string, _ time.Duration, err error) {
+	if err == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.apiErrors[nodeFromPath(path)]++
+}
+
+// observeServices records the number of guests discovered on each node.
+func (m *providerMetrics) observeServices(guests map[string]int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.guestsDiscovered = make(map[string]int, len(guests))
+	for nodeName, count := range guests {
+		m.guestsDiscovered[nodeName] = count
+	}
+}
+
+// observeConfiguration records the number of generated routers and counts the configuration push.
+func (m *providerMetrics) observeConfiguration(config *dynamic.Configuration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.routersGenerated = map[string]int{"http": 0, "tcp": 0, "udp": 0}
+	if config.HTTP != nil {
+		m.routersGenerated["http"] = len(config.HTTP.Routers)
+	}
+	if config.TCP != nil {
+		m.routersGenerated["tcp"] = len(config.TCP.Routers)
+	}
+	if config.UDP != nil {
+		m.routersGenerated["udp"] = len(config.UDP.Routers)
+	}
+	m.configPushes++
+}
+
+// WriteTo renders all metrics in the Prometheus text exposition format.
+func (m *providerMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+
+	b.WriteString("# HELP traefik_proxmox_poll_duration_seconds Duration of a full Proxmox discovery pass.\n")
+	b.WriteString("# TYPE traefik_proxmox_poll_duration_seconds histogram\n")
+	for i, bound := range pollDurationBuckets {
+		fmt.Fprintf(&b, "traefik_proxmox_poll_duration_seconds_bucket{le=\"%g\"} %d\n", bound, m.pollBuckets[i])
+	}
+	fmt.Fprintf(&b, "traefik_proxmox_poll_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.pollCount)
+	fmt.Fprintf(&b, "traefik_proxmox_poll_duration_seconds_sum %g\n", m.pollSum)
+	fmt.Fprintf(&b, "traefik_proxmox_poll_duration_seconds_count %d\n", m.pollCount)
+
+	b.WriteString("# HELP traefik_proxmox_poll_errors_total Number of discovery passes that failed.\n")
+	b.WriteString("# TYPE traefik_proxmox_poll_errors_total counter\n")
+	fmt.Fprintf(&b, "traefik_proxmox_poll_errors_total %d\n", m.pollErrors)
+
+	b.WriteString("# HELP traefik_proxmox_api_errors_total Number of failed Proxmox API requests per node.\n")
+	b.WriteString("# TYPE traefik_proxmox_api_errors_total counter\n")
+	for _, node := range sortedKeys(m.apiErrors) {
+		fmt.Fprintf(&b, "traefik_proxmox_api_errors_total{node=%q} %d\n", node, m.apiErrors[node])
+	}
+
+	b.WriteString("# HELP traefik_proxmox_guests_discovered Number of Traefik-enabled guests discovered per node.\n")
+	b.WriteString("# TYPE traefik_proxmox_guests_discovered gauge\n")
+	for _, node := range sortedKeys(m.guestsDiscovered) {
+		fmt.Fprintf(&b, "traefik_proxmox_guests_discovered{node=%q} %d\n", node, m.guestsDiscovered[node])
+	}
+
+	b.WriteString("# HELP traefik_proxmox_routers_generated Number of routers in the last generated configuration.\n")
+	b.WriteString("# TYPE traefik_proxmox_routers_generated gauge\n")
+	for _, protocol := range sortedKeys(m.routersGenerated) {
+		fmt.Fprintf(&b, "traefik_proxmox_routers_generated{protocol=%q} %d\n", protocol, m.routersGenerated[protocol])
+	}
+
+	b.WriteString("# HELP traefik_proxmox_config_pushes_total Number of configurations sent to Traefik.\n")
+	b.WriteString("# TYPE traefik_proxmox_config_pushes_total counter\n")
+	fmt.Fprintf(&b, "traefik_proxmox_config_pushes_total %d\n", m.configPushes)
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// nodeFromPath extracts the node name from an API path such as /nodes/pve1/qemu.
+// Requests that are not scoped to a node are attributed to "cluster".
+func nodeFromPath(path string) string {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(parts) >= 2 && parts[0] == "nodes" {
+		return parts[1]
+	}
+	return "cluster"
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,92 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/proxmox"
+)
+
+// Prefixes accepted by the namespace option.
+const (
+	namespaceVMID = "vmid"
+	namespaceNode = "node"
+)
+
+// namespaceElementTypes are the elements whose label-defined names are namespaced.
+var namespaceElementTypes = []string{"routers", "services", "middlewares"}
+
+// namespaceLabels prefixes the routers, services and middlewares defined in the labels of a guest with its
+// VMID or node, e.g. traefik.http.routers.web becomes the 105-web router, so guests defining the same names
+// don't overwrite each other. References to these names are prefixed as well, while references to elements of
+// other guests or providers (name@provider) are kept. Default names already contain the VMID and are kept too.
+func namespaceLabels(service proxmox.Service, nodeName, namespace string) map[string]string {
+	var prefix string
+	switch namespace {
+	case namespaceVMID:
+		prefix = fmt.Sprintf("%d-", service.ID)
+	case namespaceNode:
+		prefix = nodeName + "-"
+	default:
+		return service.Config
+	}
+
+	defaultID := serviceID(service.Name, service.ID, service.Config)
+	defined := make(map[string]map[string]bool)
+	for _, proto := range []string{"http", "tcp", "udp"} {
+		for _, elemType := range namespaceElementTypes {
+			names := make(map[string]bool)
+			for _, name := range getDefinedElements(service.Config, proto, elemType) {
+				if name != defaultID {
+					names[name] = true
+				}
+			}
+			defined[proto+"."+elemType] = names
+		}
+	}
+
+	labels := make(map[string]string, len(service.Config))
+	for key, value := range service.Config {
+		parts := strings.SplitN(key, ".", 5)
+		if len(parts) < 4 || parts[0] != "traefik" || defined[parts[1]+"."+parts[2]] == nil {
+			labels[key] = value
+			continue
+		}
+		proto := parts[1]
+		if defined[proto+"."+parts[2]][parts[3]] {
+			parts[3] = prefix + parts[3]
+		}
+		if len(parts) == 5 {
+			if elemType := referencedElementType(parts[4]); elemType != "" {
+				value = namespaceReferences(value, prefix, defined[proto+"."+elemType])
+			}
+		}
+		labels[strings.Join(parts, ".")] = value
+	}
+	return labels
+}
+
+// referencedElementType returns the type of the elements named by the value of a label, from the end of its key.
+func referencedElementType(path string) string {
+	path = strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(path, "middlewares"):
+		return "middlewares"
+	case path == "service", strings.HasSuffix(path, ".service"), strings.HasSuffix(path, ".fallback"),
+		strings.HasPrefix(path, "mirroring.mirrors") && strings.HasSuffix(path, ".name"):
+		return "services"
+	}
+	return ""
+}
+
+// namespaceReferences prefixes the names of a comma-separated list that are defined by the guest.
+func namespaceReferences(value, prefix string, names map[string]bool) string {
+	items := strings.Split(value, ",")
+	for i, item := range items {
+		name := strings.TrimSpace(item)
+		if names[name] {
+			items[i] = prefix + name
+		}
+	}
+	return strings.Join(items, ",")
+}
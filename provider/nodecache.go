@@ -0,0 +1,59 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/NX211/traefik-proxmox-provider/proxmox"
+)
+
+// NodeCache keeps the node list of the cluster between polls, refreshing it on its own interval as
+// the cluster membership changes far less often than the guests. A nil cache lists the nodes every time.
+type NodeCache struct {
+	interval time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	nodes   []proxmox.NodeStatus
+	fetched time.Time
+}
+
+// NewNodeCache creates a cache listing the nodes again once the interval elapsed.
+func NewNodeCache(interval time.Duration) *NodeCache {
+	return &NodeCache{interval: interval, now: time.Now}
+}
+
+// newNodeCache creates a cache from a duration, or returns nil when it is empty.
+func newNodeCache(interval string) (*NodeCache, error) {
+	if interval == "" {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(interval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid interval: %w", err)
+	}
+	return NewNodeCache(d), nil
+}
+
+// list returns the nodes of the cluster, from the cache while it is fresh. A failed refresh is returned as
+// is, the scan of the guests needs the API anyway.
+func (c *NodeCache) list(client *proxmox.ProxmoxClient, ctx context.Context) ([]proxmox.NodeStatus, error) {
+	if c == nil {
+		return client.GetNodes(ctx)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.nodes != nil && c.now().Sub(c.fetched) < c.interval {
+		return c.nodes, nil
+	}
+	nodes, err := client.GetNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.nodes = nodes
+	c.fetched = c.now()
+	return nodes, nil
+}
@@ -0,0 +1,171 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/dynamic"
+	"github.com/NX211/traefik-proxmox-provider/dynamic/tls"
+)
+
+// redactedPrivateKey replaces the private keys redacted from a configuration.
+const redactedPrivateKey = "REDACTED"
+
+// configurationOutput receives every configuration generated by the provider,
+// in addition to the configuration sent to Traefik.
+type configurationOutput interface {
+	Write(ctx context.Context, configuration *dynamic.Configuration) error
+}
+
+// fileOutput writes the configuration to a file that can be consumed by Traefik's file provider.
+type fileOutput struct {
+	path   string
+	format string
+	last   []byte
+}
+
+func newFileOutput(path, format string) (*fileOutput, error) {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".toml":
+			format = "toml"
+		case ".json":
+			format = "json"
+		default:
+			format = "yaml"
+		}
+	}
+
+	switch format {
+	case "yaml", "toml", "json":
+	default:
+		return nil, fmt.Errorf("unsupported output format %q, expected yaml, toml or json", format)
+	}
+
+	return &fileOutput{path: path, format: format}, nil
+}
+
+// Write replaces the file atomically, and only when its content changed.
+func (o *fileOutput) Write(_ context.Context, configuration *dynamic.Configuration) error {
+	content, err := encodeOutput(configuration, o.format)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(content, o.last) {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(o.path), "."+filepath.Base(o.path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	// Only the provider may read a configuration carrying private keys.
+	mode := os.FileMode(0o644)
+	if hasPrivateKeys(configuration) {
+		mode = 0o600
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set permissions on temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), o.path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", o.path, err)
+	}
+
+	o.last = content
+	return nil
+}
+
+func encodeOutput(configuration *dynamic.Configuration, format string) ([]byte, error) {
+	switch format {
+	case "toml":
+		return dynamic.MarshalTOML(configuration)
+	case "json":
+		content, err := json.MarshalIndent(configuration, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(content, '\n'), nil
+	default:
+		return dynamic.MarshalYAML(configuration)
+	}
+}
+
+// redactPrivateKeys returns a copy of the configuration without the PEM private keys it carries, such as those
+// read from the tls.key snippets of the guests, for the /config endpoint and the outputs. Key file paths are
+// kept, and a configuration without private keys is returned as is.
+func redactPrivateKeys(configuration *dynamic.Configuration) *dynamic.Configuration {
+	if configuration == nil || !hasPrivateKeys(configuration) {
+		return configuration
+	}
+	redacted := copyConfiguration(configuration)
+	forEachCertificate(redacted, func(certificate *tls.Certificate) {
+		if isPEMContent(certificate.KeyFile) {
+			certificate.KeyFile = redactedPrivateKey
+		}
+	})
+	return redacted
+}
+
+// hasPrivateKeys reports whether a configuration carries PEM private keys.
+func hasPrivateKeys(configuration *dynamic.Configuration) bool {
+	found := false
+	forEachCertificate(configuration, func(certificate *tls.Certificate) {
+		found = found || isPEMContent(certificate.KeyFile)
+	})
+	return found
+}
+
+// forEachCertificate calls fn with the TLS certificates of a configuration, the default certificates of its
+// TLS stores and the client certificates of its servers transports.
+func forEachCertificate(configuration *dynamic.Configuration, fn func(*tls.Certificate)) {
+	if configuration.TLS != nil {
+		for _, certificate := range configuration.TLS.Certificates {
+			if certificate != nil {
+				fn(&certificate.Certificate)
+			}
+		}
+		for _, store := range configuration.TLS.Stores {
+			if store.DefaultCertificate != nil {
+				fn(store.DefaultCertificate)
+			}
+		}
+	}
+	if configuration.HTTP != nil {
+		for _, transport := range configuration.HTTP.ServersTransports {
+			if transport != nil {
+				for i := range transport.Certificates {
+					fn(&transport.Certificates[i])
+				}
+			}
+		}
+	}
+	if configuration.TCP != nil {
+		for _, transport := range configuration.TCP.ServersTransports {
+			if transport != nil && transport.TLS != nil {
+				for i := range transport.TLS.Certificates {
+					fn(&transport.TLS.Certificates[i])
+				}
+			}
+		}
+	}
+}
+
+// isPEMContent reports whether a certFile or keyFile holds PEM content, which Traefik accepts in place of a path.
+func isPEMContent(value string) bool {
+	return strings.Contains(value, "-----BEGIN ")
+}
@@ -0,0 +1,343 @@
+package provider
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NX211/traefik-proxmox-provider/dynamic"
+)
+
+const (
+	probeModeDrop  = "drop"
+	probeModeDrain = "drain"
+
+	defaultProbeTimeout = 2 * time.Second
+	// maxConcurrentProbes bounds the number of connections opened at once.
+	maxConcurrentProbes = 32
+)
+
+// serverProbe checks that the HTTP and TCP servers of a configuration accept connections, so an
+// address on a network Traefik can't reach doesn't end up as a server that fails every request.
+type serverProbe struct {
+	// mode is drop to remove unreachable servers, or drain to keep HTTP servers with weight 0.
+	mode    string
+	timeout time.Duration
+	dial    func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+func newServerProbe(mode, timeout string) (*serverProbe, error) {
+	switch mode {
+	case "", "false":
+		return nil, nil
+	case probeModeDrop, probeModeDrain:
+	default:
+		return nil, fmt.Errorf("unknown mode %q, expected drop or drain", mode)
+	}
+
+	probe := &serverProbe{mode: mode, timeout: defaultProbeTimeout}
+	if timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w", err)
+		}
+		probe.timeout = d
+	}
+	dialer := &net.Dialer{Timeout: probe.timeout}
+	probe.dial = dialer.DialContext
+	return probe, nil
+}
+
+// apply probes the servers of the configuration and removes or drains the unreachable ones. TCP servers
+// have no weight, so they're removed in both modes. Servers that already have weight 0 are not probed.
+func (p *serverProbe) apply(ctx context.Context, config *dynamic.Configuration) {
+	var addresses []string
+	if config.HTTP != nil {
+		for _, service := range config.HTTP.Services {
+			if service.LoadBalancer == nil {
+				continue
+			}
+			for _, server := range service.LoadBalancer.Servers {
+				if server.Weight != nil && *server.Weight == 0 {
+					continue
+				}
+				if address := httpServerAddress(server.URL); address != "" {
+					addresses = append(addresses, address)
+				}
+			}
+		}
+	}
+	if config.TCP != nil {
+		for _, service := range config.TCP.Services {
+			if service.LoadBalancer == nil {
+				continue
+			}
+			for _, server := range service.LoadBalancer.Servers {
+				if server.Address != "" {
+					addresses = append(addresses, server.Address)
+				}
+			}
+		}
+	}
+
+	reachable := p.probe(ctx, addresses)
+
+	if config.HTTP != nil {
+		for name, service := range config.HTTP.Services {
+			if service.LoadBalancer == nil {
+				continue
+			}
+			servers := service.LoadBalancer.Servers[:0]
+			for _, server := range service.LoadBalancer.Servers {
+				drained := server.Weight != nil && *server.Weight == 0
+				if ok, probed := reachable[httpServerAddress(server.URL)]; probed && !ok && !drained {
+					if p.mode == probeModeDrop {
+						log.Printf("Server %s of service %s is unreachable, dropping it", server.URL, name)
+						continue
+					}
+					log.Printf("Server %s of service %s is unreachable, draining it", server.URL, name)
+					server.Weight = new(int)
+				}
+				servers = append(servers, server)
+			}
+			service.LoadBalancer.Servers = servers
+		}
+	}
+	if config.TCP != nil {
+		for name, service := range config.TCP.Services {
+			if service.LoadBalancer == nil {
+				continue
+			}
+			servers := service.LoadBalancer.Servers[:0]
+			for _, server := range service.LoadBalancer.Servers {
+				if ok, probed := reachable[server.Address]; probed && !ok {
+					log.Printf("TCP server %s of service %s is unreachable, dropping it", server.Address, name)
+					continue
+				}
+				servers = append(servers, server)
+			}
+			service.LoadBalancer.Servers = servers
+		}
+	}
+}
+
+// probe dials each address once and reports whether it accepted the connection.
+func (p *serverProbe) probe(ctx context.Context, addresses []string) map[string]bool {
+	reachable := make(map[string]bool, len(addresses))
+	var unique []string
+	for _, address := range addresses {
+		if _, ok := reachable[address]; !ok {
+			reachable[address] = false
+			unique = append(unique, address)
+		}
+	}
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, maxConcurrentProbes)
+	)
+	for _, address := range unique {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(address string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			dialCtx, cancel := context.WithTimeout(ctx, p.timeout)
+			defer cancel()
+			conn, err := p.dial(dialCtx, "tcp", address)
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+
+			mu.Lock()
+			reachable[address] = true
+			mu.Unlock()
+		}(address)
+	}
+	wg.Wait()
+	return reachable
+}
+
+// httpServerAddress returns the host:port of a server URL, using the default port of its scheme.
+func httpServerAddress(serverURL string) string {
+	u, err := url.Parse(serverURL)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "https":
+			port = "443"
+		case "http", "h2c":
+			port = "80"
+		default:
+			return ""
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// probedHTTPSTransport names the servers transport of the services with servers switched to https by the
+// scheme probe, which skips the verification of their mostly self-signed certificates.
+const probedHTTPSTransport = "probed-https"
+
+// schemeProbe detects the scheme of HTTP servers declared with a port but no scheme, by trying a TLS
+// handshake on the port: servers that complete it are switched to https, the others stay http.
+type schemeProbe struct {
+	timeout time.Duration
+	// transport is the servers transport of the services switched to https, probedHTTPSTransport when empty.
+	transport string
+	// detectScheme returns https or http for an address, or an empty string when it can't be reached.
+	detectScheme func(ctx context.Context, address string) string
+
+	mu sync.Mutex
+	// schemes caches the detected scheme of each address, as appliances rarely change it.
+	schemes map[string]string
+}
+
+func newSchemeProbe(enabled, timeout, transport string) (*schemeProbe, error) {
+	if enabled != "true" {
+		return nil, nil
+	}
+	probe := &schemeProbe{timeout: defaultProbeTimeout, transport: transport, schemes: make(map[string]string)}
+	if timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %w", err)
+		}
+		probe.timeout = d
+	}
+	probe.detectScheme = probe.tlsHandshake
+	return probe, nil
+}
+
+// apply sets the detected scheme on the HTTP servers without an explicit scheme or URL label. The services
+// with servers switched to https get the servers transport of the probe, unless they already have one.
+func (p *schemeProbe) apply(ctx context.Context, config *dynamic.Configuration) {
+	if config.HTTP == nil {
+		return
+	}
+
+	var servers []*dynamic.Server
+	var services []*dynamic.ServersLoadBalancer
+	var addresses []string
+	for _, service := range config.HTTP.Services {
+		if service.LoadBalancer == nil {
+			continue
+		}
+		for i := range service.LoadBalancer.Servers {
+			server := &service.LoadBalancer.Servers[i]
+			if server.Scheme != "" || server.Port == "" || !strings.HasPrefix(server.URL, "http://") {
+				continue
+			}
+			servers = append(servers, server)
+			services = append(services, service.LoadBalancer)
+			addresses = append(addresses, httpServerAddress(server.URL))
+		}
+	}
+
+	schemes := p.detect(ctx, addresses)
+	for i, server := range servers {
+		if schemes[addresses[i]] != "https" {
+			continue
+		}
+		server.URL = "https://" + strings.TrimPrefix(server.URL, "http://")
+		if services[i].ServersTransport != "" {
+			continue
+		}
+		if p.transport != "" {
+			services[i].ServersTransport = p.transport
+			continue
+		}
+		if config.HTTP.ServersTransports == nil {
+			config.HTTP.ServersTransports = make(map[string]*dynamic.ServersTransport)
+		}
+		config.HTTP.ServersTransports[probedHTTPSTransport] = &dynamic.ServersTransport{InsecureSkipVerify: true}
+		services[i].ServersTransport = probedHTTPSTransport
+	}
+}
+
+// detect returns the scheme of each address, probing the addresses that are not cached yet. Addresses that
+// are unreachable or whose handshake failed without a definite answer are probed again on the next poll.
+func (p *schemeProbe) detect(ctx context.Context, addresses []string) map[string]string {
+	p.mu.Lock()
+	schemes := make(map[string]string, len(addresses))
+	var unknown []string
+	for _, address := range addresses {
+		if _, ok := schemes[address]; ok {
+			continue
+		}
+		scheme, ok := p.schemes[address]
+		schemes[address] = scheme
+		if !ok {
+			unknown = append(unknown, address)
+		}
+	}
+	p.mu.Unlock()
+
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, maxConcurrentProbes)
+	)
+	for _, address := range unknown {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(address string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			scheme := p.detectScheme(ctx, address)
+			if scheme == "" {
+				return
+			}
+			p.mu.Lock()
+			p.schemes[address] = scheme
+			p.mu.Unlock()
+		}(address)
+	}
+	wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, address := range unknown {
+		schemes[address] = p.schemes[address]
+	}
+	return schemes
+}
+
+// tlsHandshake returns https when the address completes a TLS handshake and http when it answers the
+// handshake with something else than TLS, e.g. a 400 Bad Request of a HTTP server. Handshakes that time
+// out or are cut, as by a guest still starting, return an empty string so they aren't cached.
+// Certificates are not verified, appliances mostly use self-signed ones.
+func (p *schemeProbe) tlsHandshake(ctx context.Context, address string) string {
+	dialCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(dialCtx, "tcp", address)
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	if err := tlsConn.HandshakeContext(dialCtx); err != nil {
+		var recordErr tls.RecordHeaderError
+		if errors.As(err, &recordErr) {
+			return "http"
+		}
+		return ""
+	}
+	return "https"
+}
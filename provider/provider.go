@@ -1,4 +1,10 @@
 // Package provider is a plugin to use a proxmox cluster as an provider.
+//
+// Besides the Traefik plugin lifecycle (New, Provide, Stop), the discovery can be used on its own:
+//
+//	client := provider.NewClient(parserConfig)
+//	servicesMap, _, err := provider.GetServiceMap(client, ctx, provider.DiscoveryOptions{})
+//	configuration := provider.GenerateConfiguration(servicesMap)
 package provider
 
 import (
@@ -7,20 +13,102 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/NX211/traefik-proxmox-provider/dynamic"
-	"github.com/NX211/traefik-proxmox-provider/internal"
+	"github.com/NX211/traefik-proxmox-provider/proxmox"
 )
 
 // Config the plugin configuration.
 type Config struct {
-	PollInterval   string `json:"pollInterval" yaml:"pollInterval" toml:"pollInterval"`
-	ApiEndpoint    string `json:"apiEndpoint" yaml:"apiEndpoint" toml:"apiEndpoint"`
-	ApiTokenId     string `json:"apiTokenId" yaml:"apiTokenId" toml:"apiTokenId"`
-	ApiToken       string `json:"apiToken" yaml:"apiToken" toml:"apiToken"`
-	ApiLogging     string `json:"apiLogging" yaml:"apiLogging" toml:"apiLogging"`
-	ApiValidateSSL string `json:"apiValidateSSL" yaml:"apiValidateSSL" toml:"apiValidateSSL"`
+	PollInterval            string            `json:"pollInterval" yaml:"pollInterval" toml:"pollInterval"`
+	ApiEndpoint             string            `json:"apiEndpoint" yaml:"apiEndpoint" toml:"apiEndpoint"`
+	ApiTokenId              string            `json:"apiTokenId" yaml:"apiTokenId" toml:"apiTokenId"`
+	ApiToken                string            `json:"apiToken" yaml:"apiToken" toml:"apiToken"`
+	ApiLogging              string            `json:"apiLogging" yaml:"apiLogging" toml:"apiLogging"`
+	ApiValidateSSL          string            `json:"apiValidateSSL" yaml:"apiValidateSSL" toml:"apiValidateSSL"`
+	ListenAddress           string            `json:"listenAddress,omitempty" yaml:"listenAddress,omitempty" toml:"listenAddress,omitempty"`
+	TracingEndpoint         string            `json:"tracingEndpoint,omitempty" yaml:"tracingEndpoint,omitempty" toml:"tracingEndpoint,omitempty"`
+	OutputFile              string            `json:"outputFile,omitempty" yaml:"outputFile,omitempty" toml:"outputFile,omitempty"`
+	OutputFormat            string            `json:"outputFormat,omitempty" yaml:"outputFormat,omitempty" toml:"outputFormat,omitempty"`
+	KVEndpoint              string            `json:"kvEndpoint,omitempty" yaml:"kvEndpoint,omitempty" toml:"kvEndpoint,omitempty"`
+	KVRootKey               string            `json:"kvRootKey,omitempty" yaml:"kvRootKey,omitempty" toml:"kvRootKey,omitempty"`
+	OutputPrivateKeys       string            `json:"outputPrivateKeys,omitempty" yaml:"outputPrivateKeys,omitempty" toml:"outputPrivateKeys,omitempty"`
+	Nodes                   []string          `json:"nodes,omitempty" yaml:"nodes,omitempty" toml:"nodes,omitempty"`
+	ExcludeNodes            []string          `json:"excludeNodes,omitempty" yaml:"excludeNodes,omitempty" toml:"excludeNodes,omitempty"`
+	NodeRefreshInterval     string            `json:"nodeRefreshInterval,omitempty" yaml:"nodeRefreshInterval,omitempty" toml:"nodeRefreshInterval,omitempty"`
+	Pools                   []string          `json:"pools,omitempty" yaml:"pools,omitempty" toml:"pools,omitempty"`
+	ExcludePools            []string          `json:"excludePools,omitempty" yaml:"excludePools,omitempty" toml:"excludePools,omitempty"`
+	Tags                    []string          `json:"tags,omitempty" yaml:"tags,omitempty" toml:"tags,omitempty"`
+	ExcludeTags             []string          `json:"excludeTags,omitempty" yaml:"excludeTags,omitempty" toml:"excludeTags,omitempty"`
+	VMIDRanges              string            `json:"vmidRanges,omitempty" yaml:"vmidRanges,omitempty" toml:"vmidRanges,omitempty"`
+	NameFilter              string            `json:"nameFilter,omitempty" yaml:"nameFilter,omitempty" toml:"nameFilter,omitempty"`
+	NameExclude             string            `json:"nameExclude,omitempty" yaml:"nameExclude,omitempty" toml:"nameExclude,omitempty"`
+	GuestTypes              []string          `json:"guestTypes,omitempty" yaml:"guestTypes,omitempty" toml:"guestTypes,omitempty"`
+	IncludeTemplates        string            `json:"includeTemplates,omitempty" yaml:"includeTemplates,omitempty" toml:"includeTemplates,omitempty"`
+	IncludeLocked           string            `json:"includeLocked,omitempty" yaml:"includeLocked,omitempty" toml:"includeLocked,omitempty"`
+	IncludeStopped          string            `json:"includeStopped,omitempty" yaml:"includeStopped,omitempty" toml:"includeStopped,omitempty"`
+	RequireAgentPing        string            `json:"requireAgentPing,omitempty" yaml:"requireAgentPing,omitempty" toml:"requireAgentPing,omitempty"`
+	AgentTimeout            string            `json:"agentTimeout,omitempty" yaml:"agentTimeout,omitempty" toml:"agentTimeout,omitempty"`
+	MaintenanceNodes        []string          `json:"maintenanceNodes,omitempty" yaml:"maintenanceNodes,omitempty" toml:"maintenanceNodes,omitempty"`
+	HAMaintenance           string            `json:"haMaintenance,omitempty" yaml:"haMaintenance,omitempty" toml:"haMaintenance,omitempty"`
+	MaintenanceMode         string            `json:"maintenanceMode,omitempty" yaml:"maintenanceMode,omitempty" toml:"maintenanceMode,omitempty"`
+	IPFamily                string            `json:"ipFamily,omitempty" yaml:"ipFamily,omitempty" toml:"ipFamily,omitempty"`
+	DefaultInterface        string            `json:"defaultInterface,omitempty" yaml:"defaultInterface,omitempty" toml:"defaultInterface,omitempty"`
+	PreferredCIDRs          []string          `json:"preferredCIDRs,omitempty" yaml:"preferredCIDRs,omitempty" toml:"preferredCIDRs,omitempty"`
+	ExcludedCIDRs           []string          `json:"excludedCIDRs,omitempty" yaml:"excludedCIDRs,omitempty" toml:"excludedCIDRs,omitempty"`
+	OverrideExcludedCIDRs   string            `json:"overrideExcludedCIDRs,omitempty" yaml:"overrideExcludedCIDRs,omitempty" toml:"overrideExcludedCIDRs,omitempty"`
+	LocalNeighborTable      string            `json:"localNeighborTable,omitempty" yaml:"localNeighborTable,omitempty" toml:"localNeighborTable,omitempty"`
+	LocalDnsmasqLeases      string            `json:"localDnsmasqLeases,omitempty" yaml:"localDnsmasqLeases,omitempty" toml:"localDnsmasqLeases,omitempty"`
+	DHCPLeases              string            `json:"dhcpLeases,omitempty" yaml:"dhcpLeases,omitempty" toml:"dhcpLeases,omitempty"`
+	SDNIPAM                 string            `json:"sdnIpam,omitempty" yaml:"sdnIpam,omitempty" toml:"sdnIpam,omitempty"`
+	IPCacheTTL              string            `json:"ipCacheTTL,omitempty" yaml:"ipCacheTTL,omitempty" toml:"ipCacheTTL,omitempty"`
+	IPRefreshInterval       string            `json:"ipRefreshInterval,omitempty" yaml:"ipRefreshInterval,omitempty" toml:"ipRefreshInterval,omitempty"`
+	UseGuestHostname        string            `json:"useGuestHostname,omitempty" yaml:"useGuestHostname,omitempty" toml:"useGuestHostname,omitempty"`
+	GuestLabelFile          string            `json:"guestLabelFile,omitempty" yaml:"guestLabelFile,omitempty" toml:"guestLabelFile,omitempty"`
+	PublishAll              string            `json:"publishAll,omitempty" yaml:"publishAll,omitempty" toml:"publishAll,omitempty"`
+	NATMode                 string            `json:"natMode,omitempty" yaml:"natMode,omitempty" toml:"natMode,omitempty"`
+	NodeAddresses           map[string]string `json:"nodeAddresses,omitempty" yaml:"nodeAddresses,omitempty" toml:"nodeAddresses,omitempty"`
+	NATPorts                map[string]string `json:"natPorts,omitempty" yaml:"natPorts,omitempty" toml:"natPorts,omitempty"`
+	HostnameFallback        string            `json:"hostnameFallback,omitempty" yaml:"hostnameFallback,omitempty" toml:"hostnameFallback,omitempty"`
+	DefaultDomain           string            `json:"defaultDomain,omitempty" yaml:"defaultDomain,omitempty" toml:"defaultDomain,omitempty"`
+	NodeDomains             map[string]string `json:"nodeDomains,omitempty" yaml:"nodeDomains,omitempty" toml:"nodeDomains,omitempty"`
+	PoolDomains             map[string]string `json:"poolDomains,omitempty" yaml:"poolDomains,omitempty" toml:"poolDomains,omitempty"`
+	SnippetPaths            map[string]string `json:"snippetPaths,omitempty" yaml:"snippetPaths,omitempty" toml:"snippetPaths,omitempty"`
+	Strict                  string            `json:"strict,omitempty" yaml:"strict,omitempty" toml:"strict,omitempty"`
+	DefaultEntryPoints      []string          `json:"defaultEntryPoints,omitempty" yaml:"defaultEntryPoints,omitempty" toml:"defaultEntryPoints,omitempty"`
+	PortEntryPoints         map[string]string `json:"portEntryPoints,omitempty" yaml:"portEntryPoints,omitempty" toml:"portEntryPoints,omitempty"`
+	DefaultMiddlewares      []string          `json:"defaultMiddlewares,omitempty" yaml:"defaultMiddlewares,omitempty" toml:"defaultMiddlewares,omitempty"`
+	DefaultCertResolver     string            `json:"defaultCertResolver,omitempty" yaml:"defaultCertResolver,omitempty" toml:"defaultCertResolver,omitempty"`
+	DefaultTLSDomain        string            `json:"defaultTLSDomain,omitempty" yaml:"defaultTLSDomain,omitempty" toml:"defaultTLSDomain,omitempty"`
+	DefaultTLSSANs          []string          `json:"defaultTLSSANs,omitempty" yaml:"defaultTLSSANs,omitempty" toml:"defaultTLSSANs,omitempty"`
+	HTTPSRedirect           string            `json:"httpsRedirect,omitempty" yaml:"httpsRedirect,omitempty" toml:"httpsRedirect,omitempty"`
+	HTTPSRedirectEntryPoint string            `json:"httpsRedirectEntryPoint,omitempty" yaml:"httpsRedirectEntryPoint,omitempty" toml:"httpsRedirectEntryPoint,omitempty"`
+	DefaultTCPMiddlewares   []string          `json:"defaultTCPMiddlewares,omitempty" yaml:"defaultTCPMiddlewares,omitempty" toml:"defaultTCPMiddlewares,omitempty"`
+	DefaultHealthCheck      map[string]string `json:"defaultHealthCheck,omitempty" yaml:"defaultHealthCheck,omitempty" toml:"defaultHealthCheck,omitempty"`
+	DefaultSticky           map[string]string `json:"defaultSticky,omitempty" yaml:"defaultSticky,omitempty" toml:"defaultSticky,omitempty"`
+	AllIPs                  string            `json:"allIPs,omitempty" yaml:"allIPs,omitempty" toml:"allIPs,omitempty"`
+	MaintenanceURL          string            `json:"maintenanceURL,omitempty" yaml:"maintenanceURL,omitempty" toml:"maintenanceURL,omitempty"`
+	Namespace               string            `json:"namespace,omitempty" yaml:"namespace,omitempty" toml:"namespace,omitempty"`
+	LegacyPriority          string            `json:"legacyPriority,omitempty" yaml:"legacyPriority,omitempty" toml:"legacyPriority,omitempty"`
+	PassHostHeader          string            `json:"passHostHeader,omitempty" yaml:"passHostHeader,omitempty" toml:"passHostHeader,omitempty"`
+	Protocols               []string          `json:"protocols,omitempty" yaml:"protocols,omitempty" toml:"protocols,omitempty"`
+	TraefikVersion          string            `json:"traefikVersion,omitempty" yaml:"traefikVersion,omitempty" toml:"traefikVersion,omitempty"`
+	ExposeProxmoxUI         string            `json:"exposeProxmoxUI,omitempty" yaml:"exposeProxmoxUI,omitempty" toml:"exposeProxmoxUI,omitempty"`
+	ProxmoxUIConsoles       string            `json:"proxmoxUIConsoles,omitempty" yaml:"proxmoxUIConsoles,omitempty" toml:"proxmoxUIConsoles,omitempty"`
+	SpiceEntryPoint         string            `json:"spiceEntryPoint,omitempty" yaml:"spiceEntryPoint,omitempty" toml:"spiceEntryPoint,omitempty"`
+	ProbeServers            string            `json:"probeServers,omitempty" yaml:"probeServers,omitempty" toml:"probeServers,omitempty"`
+	ProbeTimeout            string            `json:"probeTimeout,omitempty" yaml:"probeTimeout,omitempty" toml:"probeTimeout,omitempty"`
+	ProbeScheme             string            `json:"probeScheme,omitempty" yaml:"probeScheme,omitempty" toml:"probeScheme,omitempty"`
+	ProbeSchemeTransport    string            `json:"probeSchemeTransport,omitempty" yaml:"probeSchemeTransport,omitempty" toml:"probeSchemeTransport,omitempty"`
+	Defaults                map[string]string `json:"defaults,omitempty" yaml:"defaults,omitempty" toml:"defaults,omitempty"`
+	NodeLabels              map[string]string `json:"nodeLabels,omitempty" yaml:"nodeLabels,omitempty" toml:"nodeLabels,omitempty"`
+	PoolLabels              map[string]string `json:"poolLabels,omitempty" yaml:"poolLabels,omitempty" toml:"poolLabels,omitempty"`
+	PoolCommentLabels       string            `json:"poolCommentLabels,omitempty" yaml:"poolCommentLabels,omitempty" toml:"poolCommentLabels,omitempty"`
 }
 
 // CreateConfig creates the default plugin configuration.
@@ -36,8 +124,18 @@ func CreateConfig() *Config {
 type Provider struct {
 	name         string
 	pollInterval time.Duration
-	client       *internal.ProxmoxClient
-	cancel       func()
+	client       *proxmox.ProxmoxClient
+	metrics      *providerMetrics
+	status       *providerStatus
+	outputs      []configurationOutput
+	// outputPrivateKeys keeps the private keys of the configuration in the outputs, see redactPrivateKeys.
+	outputPrivateKeys bool
+	discovery         DiscoveryOptions
+	generation        ConfigurationOptions
+	probe             *serverProbe
+	schemeProbe       *schemeProbe
+	server            *internalServer
+	cancel            func()
 }
 
 // New creates a new Provider plugin.
@@ -56,7 +154,7 @@ func New(ctx context.Context, config *Config, name string) (*Provider, error) {
 		return nil, fmt.Errorf("poll interval must be at least 5 seconds, got %v", pi)
 	}
 
-	pc, err := newParserConfig(
+	pc, err := NewParserConfig(
 		config.ApiEndpoint,
 		config.ApiTokenId,
 		config.ApiToken,
@@ -67,16 +165,226 @@ func New(ctx context.Context, config *Config, name string) (*Provider, error) {
 
 	pc.LogLevel = config.ApiLogging
 	pc.ValidateSSL = config.ApiValidateSSL == "true"
-	client := newClient(pc)
+	client := NewClient(pc)
 
-	if err := logVersion(client, ctx); err != nil {
+	if config.AgentTimeout != "" {
+		if client.AgentTimeout, err = time.ParseDuration(config.AgentTimeout); err != nil {
+			return nil, fmt.Errorf("invalid agent timeout: %w", err)
+		}
+	}
+
+	metrics := newProviderMetrics()
+	client.Observer = metrics.observeAPIRequest
+	if config.TracingEndpoint != "" {
+		client.Tracer = proxmox.NewTracer(config.TracingEndpoint)
+	}
+
+	version, err := logVersion(client, ctx)
+	if err != nil {
 		return nil, fmt.Errorf("failed to get Proxmox version: %w", err)
 	}
+	legacyContainerIPs := version.Before(8, 2)
+	if legacyContainerIPs {
+		log.Printf("Proxmox VE %s has no container interfaces endpoint, using the static container addresses", version.Release)
+	}
+
+	vmidRanges, err := ParseVMIDRanges(config.VMIDRanges)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VMID ranges: %w", err)
+	}
+
+	var nameFilter, nameExclude *regexp.Regexp
+	if config.NameFilter != "" {
+		if nameFilter, err = regexp.Compile(config.NameFilter); err != nil {
+			return nil, fmt.Errorf("invalid name filter: %w", err)
+		}
+	}
+	if config.NameExclude != "" {
+		if nameExclude, err = regexp.Compile(config.NameExclude); err != nil {
+			return nil, fmt.Errorf("invalid name exclusion: %w", err)
+		}
+	}
+
+	preferredCIDRs, err := parseCIDRs(config.PreferredCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid preferred CIDRs: %w", err)
+	}
+
+	excluded := config.ExcludedCIDRs
+	if config.OverrideExcludedCIDRs != "true" {
+		excluded = append(append([]string{}, defaultExcludedCIDRs...), excluded...)
+	}
+	excludedCIDRs, err := parseCIDRs(excluded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid excluded CIDRs: %w", err)
+	}
+
+	var macResolvers []MACResolver
+	switch config.LocalNeighborTable {
+	case "", "false":
+	case "true":
+		macResolvers = append(macResolvers, &LocalNeighborTable{})
+	default:
+		macResolvers = append(macResolvers, &LocalNeighborTable{Path: config.LocalNeighborTable})
+	}
+	if config.LocalDnsmasqLeases != "" {
+		macResolvers = append(macResolvers, &DnsmasqLeases{Path: config.LocalDnsmasqLeases})
+	}
+	if config.DHCPLeases != "" {
+		resolver, err := newLeaseResolver(config.DHCPLeases)
+		if err != nil {
+			return nil, err
+		}
+		macResolvers = append(macResolvers, resolver)
+	}
+	if config.SDNIPAM != "" {
+		macResolvers = append(macResolvers, &SDNIPAM{Client: client, IPAM: config.SDNIPAM})
+	}
+
+	nodeCache, err := newNodeCache(config.NodeRefreshInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node refresh interval: %w", err)
+	}
+
+	ipCache, err := newIPCache(config.IPCacheTTL, config.IPRefreshInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP cache: %w", err)
+	}
+
+	defaultHealthCheck, err := parseHealthCheck(config.DefaultHealthCheck)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default health check: %w", err)
+	}
+	defaultSticky, err := parseSticky(config.DefaultSticky)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default sticky sessions: %w", err)
+	}
+
+	probe, err := newServerProbe(config.ProbeServers, config.ProbeTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server probe: %w", err)
+	}
+	schemeProbe, err := newSchemeProbe(config.ProbeScheme, config.ProbeTimeout, config.ProbeSchemeTransport)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scheme probe: %w", err)
+	}
+
+	guestLabelFile := config.GuestLabelFile
+	switch guestLabelFile {
+	case "false":
+		guestLabelFile = ""
+	case "true":
+		guestLabelFile = defaultGuestLabelFile
+	}
+
+	proxmoxUIHost := config.ExposeProxmoxUI
+	if proxmoxUIHost == "false" {
+		proxmoxUIHost = ""
+	}
+
+	status := newProviderStatus(pi)
+
+	var outputs []configurationOutput
+	if config.OutputFile != "" {
+		output, err := newFileOutput(config.OutputFile, config.OutputFormat)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file output: %w", err)
+		}
+		outputs = append(outputs, output)
+	}
+	if config.KVEndpoint != "" {
+		output, err := newKVOutput(config.KVEndpoint, config.KVRootKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid KV output: %w", err)
+		}
+		outputs = append(outputs, output)
+	}
+
+	var server *internalServer
+	if config.ListenAddress != "" {
+		server = newInternalServer(config.ListenAddress, metrics, status)
+	}
 
 	return &Provider{
-		name:         name,
-		pollInterval: pi,
-		client:       client,
+		name:              name,
+		pollInterval:      pi,
+		client:            client,
+		metrics:           metrics,
+		status:            status,
+		outputs:           outputs,
+		outputPrivateKeys: config.OutputPrivateKeys == "true",
+		discovery: DiscoveryOptions{
+			Nodes:               config.Nodes,
+			ExcludeNodes:        config.ExcludeNodes,
+			Pools:               config.Pools,
+			ExcludePools:        config.ExcludePools,
+			Tags:                config.Tags,
+			ExcludeTags:         config.ExcludeTags,
+			VMIDRanges:          vmidRanges,
+			NameFilter:          nameFilter,
+			NameExclude:         nameExclude,
+			GuestTypes:          config.GuestTypes,
+			IncludeTemplates:    config.IncludeTemplates == "true",
+			IncludeLocked:       config.IncludeLocked == "true",
+			IncludeStopped:      config.IncludeStopped == "true",
+			RequireAgentPing:    config.RequireAgentPing == "true",
+			MaintenanceNodes:    config.MaintenanceNodes,
+			DetectHAMaintenance: config.HAMaintenance == "true",
+			MaintenanceMode:     config.MaintenanceMode,
+			IPFamily:            config.IPFamily,
+			DefaultInterface:    config.DefaultInterface,
+			PreferredCIDRs:      preferredCIDRs,
+			ExcludedCIDRs:       excludedCIDRs,
+			MACResolvers:        macResolvers,
+			UseGuestHostname:    config.UseGuestHostname == "true",
+			LegacyContainerIPs:  legacyContainerIPs,
+			HostnameFallback:    config.HostnameFallback,
+			Protocols:           config.Protocols,
+			DefaultDomain:       config.DefaultDomain,
+			NodeDomains:         config.NodeDomains,
+			PoolDomains:         config.PoolDomains,
+			SnippetPaths:        config.SnippetPaths,
+			Defaults:            config.Defaults,
+			NodeLabels:          parseLabelMap(config.NodeLabels),
+			PoolLabels:          parseLabelMap(config.PoolLabels),
+			PoolCommentLabels:   config.PoolCommentLabels == "true",
+			GuestLabelFile:      guestLabelFile,
+			IPCache:             ipCache,
+			AgentBackoff:        NewAgentBackoff(),
+			NodeCache:           nodeCache,
+			PublishAll:          config.PublishAll == "true",
+			NATMode:             config.NATMode == "true",
+			NodeAddresses:       config.NodeAddresses,
+			NATPorts:            config.NATPorts,
+		},
+		generation: ConfigurationOptions{
+			Strict:                  config.Strict == "true",
+			DefaultTCPMiddlewares:   config.DefaultTCPMiddlewares,
+			DefaultHealthCheck:      defaultHealthCheck,
+			DefaultSticky:           defaultSticky,
+			AllIPs:                  config.AllIPs == "true",
+			MaintenanceURL:          config.MaintenanceURL,
+			Namespace:               config.Namespace,
+			LegacyPriority:          config.LegacyPriority == "true",
+			DefaultEntryPoints:      config.DefaultEntryPoints,
+			DefaultMiddlewares:      config.DefaultMiddlewares,
+			DefaultCertResolver:     config.DefaultCertResolver,
+			HTTPSRedirect:           config.HTTPSRedirect == "true",
+			HTTPSRedirectEntryPoint: config.HTTPSRedirectEntryPoint,
+			DefaultTLSDomain:        config.DefaultTLSDomain,
+			DefaultTLSSANs:          config.DefaultTLSSANs,
+			PortEntryPoints:         config.PortEntryPoints,
+			DisablePassHostHeader:   config.PassHostHeader == "false",
+			Protocols:               config.Protocols,
+			TraefikVersion:          config.TraefikVersion,
+			ProxmoxUIHost:           proxmoxUIHost,
+			ProxmoxUIConsoles:       config.ProxmoxUIConsoles == "true",
+			SpiceEntryPoint:         config.SpiceEntryPoint,
+			DecodeCache:             NewDecodeCache(),
+		},
+		probe:       probe,
+		schemeProbe: schemeProbe,
+		server:      server,
 	}, nil
 }
 
@@ -87,6 +395,12 @@ func (p *Provider) Init() error {
 
 // Provide creates and send dynamic configuration.
 func (p *Provider) Provide(cfgChan chan<- json.Marshaler) error {
+	if p.server != nil {
+		if err := p.server.start(); err != nil {
+			return fmt.Errorf("failed to start internal HTTP listener: %w", err)
+		}
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	p.cancel = cancel
 
@@ -125,21 +439,77 @@ func (p *Provider) loadConfiguration(ctx context.Context, cfgChan chan<- json.Ma
 }
 
 func (p *Provider) updateConfiguration(ctx context.Context, cfgChan chan<- json.Marshaler) error {
-	servicesMap, err := getServiceMap(p.client, ctx)
+	configuration, err := p.Discover(ctx)
 	if err != nil {
-		return fmt.Errorf("error getting service map: %w", err)
+		return err
 	}
 
-	configuration := generateConfiguration(servicesMap)
 	cfgChan <- &dynamic.JSONPayload{Configuration: configuration}
+	p.metrics.observeConfiguration(configuration)
+	p.status.recordConfiguration(configuration)
+
+	written := configuration
+	if !p.outputPrivateKeys {
+		written = redactPrivateKeys(configuration)
+	}
+	for _, output := range p.outputs {
+		if err := output.Write(ctx, written); err != nil {
+			log.Printf("Error writing configuration output: %v", err)
+		}
+	}
 	return nil
 }
 
+// Discover runs a single discovery pass and returns the generated configuration without sending it to Traefik.
+func (p *Provider) Discover(ctx context.Context) (*dynamic.Configuration, error) {
+	defer p.flushTraces(ctx)
+
+	pollCtx, span := p.client.Tracer.Start(ctx, "poll", proxmox.SpanKindInternal, nil)
+	generation := p.generation
+	if generation.ProxmoxUIHost != "" {
+		generation.NodeAddresses = p.discovery.nodeAddresses(p.client, pollCtx)
+	}
+
+	// The guests of each node are built as soon as the node is scanned, so the services of the whole cluster
+	// are never held at once.
+	builder := newConfigurationBuilder(generation)
+	start := time.Now()
+	nodeErrors, err := streamServices(p.client, pollCtx, p.discovery, builder.addNode)
+	p.metrics.observePoll(time.Since(start), err)
+	guests := builder.guestCounts()
+	p.status.recordPoll(time.Now(), guests, nodeErrors, err)
+	span.RecordError(err)
+	span.End()
+	if err != nil {
+		return nil, fmt.Errorf("error getting service map: %w", err)
+	}
+	p.metrics.observeServices(guests)
+
+	configuration, guestErrors := builder.build()
+	p.status.recordGuestErrors(guestErrors)
+	if p.schemeProbe != nil {
+		p.schemeProbe.apply(ctx, configuration)
+	}
+	if p.probe != nil {
+		p.probe.apply(ctx, configuration)
+	}
+	return configuration, nil
+}
+
+func (p *Provider) flushTraces(ctx context.Context) {
+	if err := p.client.Tracer.Flush(ctx); err != nil {
+		log.Printf("Error exporting traces: %v", err)
+	}
+}
+
 // Stop to stop the provider and the related go routines.
 func (p *Provider) Stop() error {
 	if p.cancel != nil {
 		p.cancel()
 	}
+	if p.server != nil {
+		return p.server.stop()
+	}
 	return nil
 }
 
@@ -152,7 +522,8 @@ type ParserConfig struct {
 	ValidateSSL bool
 }
 
-func newParserConfig(apiEndpoint, tokenID, token string) (ParserConfig, error) {
+// NewParserConfig creates a parser configuration with the default log level and SSL validation.
+func NewParserConfig(apiEndpoint, tokenID, token string) (ParserConfig, error) {
 	if apiEndpoint == "" || tokenID == "" || token == "" {
 		return ParserConfig{}, errors.New("missing mandatory values: apiEndpoint, tokenID or token")
 	}
@@ -178,6 +549,9 @@ func validateConfig(config *Config) error {
 	if config.ApiEndpoint == "" {
 		return errors.New("API endpoint must be set")
 	}
+	if u, err := url.Parse(config.ApiEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid API endpoint %q, expected a URL like https://proxmox.example.com:8006", config.ApiEndpoint)
+	}
 
 	if config.ApiTokenId == "" {
 		return errors.New("API token ID must be set")
@@ -187,6 +561,66 @@ func validateConfig(config *Config) error {
 		return errors.New("API token must be set")
 	}
 
+	switch config.MaintenanceMode {
+	case "", maintenanceModeDrain, maintenanceModeDrop:
+	default:
+		return fmt.Errorf("unknown maintenance mode %q, expected drain or drop", config.MaintenanceMode)
+	}
+
+	if config.MaintenanceURL != "" {
+		if u, err := url.Parse(config.MaintenanceURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid maintenance URL %q", config.MaintenanceURL)
+		}
+	}
+
+	switch config.Namespace {
+	case "", namespaceVMID, namespaceNode:
+	default:
+		return fmt.Errorf("unknown namespace %q, expected vmid or node", config.Namespace)
+	}
+
+	for vmID := range config.NATPorts {
+		if _, err := strconv.ParseUint(vmID, 10, 64); err != nil {
+			return fmt.Errorf("invalid NAT ports VMID %q", vmID)
+		}
+	}
+
+	switch config.HostnameFallback {
+	case "", hostnameFallbackUnchecked, hostnameFallbackLog, hostnameFallbackSkip:
+	default:
+		return fmt.Errorf("unknown hostname fallback %q, expected unchecked, log or skip", config.HostnameFallback)
+	}
+
+	switch config.IPFamily {
+	case "", ipFamilyIPv4, ipFamilyIPv6, ipFamilyPreferIPv6, ipFamilyDual:
+	default:
+		return fmt.Errorf("unknown IP family %q, expected ipv4, ipv6, prefer-ipv6 or dual", config.IPFamily)
+	}
+
+	if config.ExposeProxmoxUI == "true" {
+		return errors.New("exposeProxmoxUI must be the host of the Proxmox web interface, e.g. pve.example.com")
+	}
+
+	switch config.TraefikVersion {
+	case "", traefikV2, traefikV3:
+	default:
+		return fmt.Errorf("unknown Traefik version %q, expected v2 or v3", config.TraefikVersion)
+	}
+
+	for _, protocol := range config.Protocols {
+		switch strings.ToLower(protocol) {
+		case "http", "tcp", "udp":
+		default:
+			return fmt.Errorf("unknown protocol %q, expected http, tcp or udp", protocol)
+		}
+	}
+
+	for _, guestType := range config.GuestTypes {
+		if !strings.EqualFold(guestType, guestTypeQemu) && !strings.EqualFold(guestType, guestTypeLXC) {
+			return fmt.Errorf("unknown guest type %q, expected qemu or lxc", guestType)
+		}
+	}
+
 	return nil
 }
 
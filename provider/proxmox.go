@@ -2,46 +2,206 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
+	"strconv"
 
-	"github.com/NX211/traefik-proxmox-provider/internal"
+	"github.com/NX211/traefik-proxmox-provider/proxmox"
 )
 
-func newClient(pc ParserConfig) *internal.ProxmoxClient {
-	return internal.NewProxmoxClient(pc.ApiEndpoint, pc.TokenId, pc.Token, pc.ValidateSSL, pc.LogLevel)
+// NewClient creates a Proxmox API client from a parser configuration.
+func NewClient(pc ParserConfig) *proxmox.ProxmoxClient {
+	return proxmox.NewProxmoxClient(pc.ApiEndpoint, pc.TokenId, pc.Token, pc.ValidateSSL, pc.LogLevel)
 }
 
-func logVersion(client *internal.ProxmoxClient, ctx context.Context) error {
+func logVersion(client *proxmox.ProxmoxClient, ctx context.Context) (*proxmox.Version, error) {
 	version, err := client.GetVersion(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	log.Printf("Connected to Proxmox VE version %s", version.Release)
-	return nil
+	return version, nil
 }
 
-func getServiceMap(client *internal.ProxmoxClient, ctx context.Context) (map[string][]internal.Service, error) {
-	servicesMap := make(map[string][]internal.Service)
+// errNodeOffline is the error of the nodes skipped because the cluster reports them offline.
+var errNodeOffline = errors.New("node is offline")
 
-	nodes, err := client.GetNodes(ctx)
+// GetServiceMap scans all nodes of the cluster and returns the running, Traefik-enabled services
+// per node, together with the errors of the nodes that could not be scanned.
+func GetServiceMap(client *proxmox.ProxmoxClient, ctx context.Context, opts DiscoveryOptions) (map[string][]proxmox.Service, map[string]error, error) {
+	servicesMap := make(map[string][]proxmox.Service)
+	nodeErrors, err := streamServices(client, ctx, opts, func(nodeName string, services []proxmox.Service) {
+		servicesMap[nodeName] = append([]proxmox.Service(nil), services...)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return dedupeByVMID(servicesMap), nodeErrors, nil
+}
+
+// streamServices scans the nodes in the order of their names and hands the services of each node to add as
+// soon as it was scanned, instead of collecting the services of the cluster, e.g. to a configurationBuilder.
+// The slice of services is reused for the next node, add must not keep it. The duplicates of migrating
+// guests are left to add. It returns the errors of the nodes that could not be scanned.
+func streamServices(client *proxmox.ProxmoxClient, ctx context.Context, opts DiscoveryOptions, add func(nodeName string, services []proxmox.Service)) (map[string]error, error) {
+	nodeErrors := make(map[string]error)
+
+	nodes, err := opts.NodeCache.list(client, ctx)
 	if err != nil {
 		return nil, fmt.Errorf("error scanning nodes: %w", err)
 	}
+	// The node cache keeps its list, the names are sorted on a copy.
+	nodes = append([]proxmox.NodeStatus(nil), nodes...)
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Node < nodes[j].Node })
+
+	filter, err := newGuestFilter(client, ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	beginPoll(opts.MACResolvers)
 
+	maintenance := opts.maintenanceNodes(client, ctx)
+	clusterStatus, err := client.GetClusterStatus(ctx)
+	if err != nil {
+		log.Printf("Error getting the cluster status, scanning every node: %v", err)
+	}
+	offline := offlineNodes(clusterStatus)
+	var nodeAddresses map[string]string
+	if opts.NATMode {
+		nodeAddresses = opts.clusterNodeAddresses(clusterStatus)
+	}
+
+	var buffer []proxmox.Service
 	for _, nodeStatus := range nodes {
-		services, err := scanServices(client, ctx, nodeStatus.Node)
+		if !opts.includesNode(nodeStatus.Node) {
+			if client.LogLevel == proxmox.LogLevelDebug {
+				log.Printf("DEBUG: Skipping node %s because it is excluded by the node filters", nodeStatus.Node)
+			}
+			continue
+		}
+
+		if offline[nodeStatus.Node] {
+			log.Printf("Skipping node %s because it is offline", nodeStatus.Node)
+			nodeErrors[nodeStatus.Node] = errNodeOffline
+			continue
+		}
+
+		if maintenance[nodeStatus.Node] && opts.MaintenanceMode == maintenanceModeDrop {
+			log.Printf("Skipping node %s because it is in maintenance", nodeStatus.Node)
+			continue
+		}
+
+		nodeCtx, span := client.Tracer.Start(ctx, "scan node", proxmox.SpanKindInternal, map[string]string{"proxmox.node": nodeStatus.Node})
+		services, err := scanServices(client, nodeCtx, nodeStatus.Node, filter, buffer)
+		span.RecordError(err)
+		span.SetAttribute("proxmox.guests", strconv.Itoa(len(services)))
+		span.End()
 		if err != nil {
 			log.Printf("Error scanning services on node %s: %v", nodeStatus.Node, err)
+			nodeErrors[nodeStatus.Node] = err
 			continue
 		}
-		servicesMap[nodeStatus.Node] = services
+		if maintenance[nodeStatus.Node] {
+			log.Printf("Draining %d services on node %s because it is in maintenance", len(services), nodeStatus.Node)
+			for i := range services {
+				services[i].Draining = true
+			}
+		}
+		if opts.NATMode {
+			if address := nodeAddresses[nodeStatus.Node]; address != "" {
+				for i := range services {
+					services[i].NodeAddress = address
+					services[i].ForwardedPorts = parseForwardedPorts(opts.NATPorts[strconv.FormatUint(services[i].ID, 10)])
+				}
+			} else {
+				log.Printf("WARNING: No address found for node %s, its guests are reached directly. Set it in nodeAddresses.", nodeStatus.Node)
+			}
+		}
+		buffer = services
+		add(nodeStatus.Node, checkHostnameFallbacks(nodeCtx, services, nodeStatus.Node, opts))
+		// Drop the references of the guests of this node, the next one overwrites the buffer.
+		for i := range buffer {
+			buffer[i] = proxmox.Service{}
+		}
+	}
+
+	// The state kept for destroyed guests, and those migrated to a node that isn't scanned, is dropped. It is
+	// kept while a node can't be scanned, as its guests may still exist.
+	if len(nodeErrors) == 0 {
+		opts.IPCache.prune(filter.seen)
+		opts.AgentBackoff.prune(filter.seen)
 	}
-	return servicesMap, nil
+	return nodeErrors, nil
 }
 
-func getIPsOfService(client *internal.ProxmoxClient, ctx context.Context, nodeName string, vmID uint64, isContainer bool) (ips []internal.IP, err error) {
-	var agentInterfaces *internal.ParsedAgentInterfaces
+// dedupeByVMID keeps a single service per VMID. During a migration a guest can be listed on both
+// the source and the target node; the copy that is running (and, failing that, has IPs) wins. The
+// duplicates are removed in place, leaving the map untouched when there are none.
+func dedupeByVMID(servicesMap map[string][]proxmox.Service) map[string][]proxmox.Service {
+	nodeNames := make([]string, 0, len(servicesMap))
+	for nodeName := range servicesMap {
+		nodeNames = append(nodeNames, nodeName)
+	}
+	sort.Strings(nodeNames)
+
+	owners := make(map[uint64]string)
+	duplicates := false
+	for _, nodeName := range nodeNames {
+		for _, service := range servicesMap[nodeName] {
+			owner, seen := owners[service.ID]
+			if !seen {
+				owners[service.ID] = nodeName
+				continue
+			}
+			duplicates = true
+			if preferService(service, findService(servicesMap[owner], service.ID)) {
+				owners[service.ID] = nodeName
+			}
+		}
+	}
+	if !duplicates {
+		return servicesMap
+	}
+
+	for _, nodeName := range nodeNames {
+		services := servicesMap[nodeName][:0]
+		for _, service := range servicesMap[nodeName] {
+			if owners[service.ID] != nodeName {
+				log.Printf("Skipping duplicate of guest %s (%d) on node %s, using the one on node %s", service.Name, service.ID, nodeName, owners[service.ID])
+				continue
+			}
+			services = append(services, service)
+		}
+		servicesMap[nodeName] = services
+	}
+	return servicesMap
+}
+
+// preferService reports whether candidate is a better representation of a guest than current.
+func preferService(candidate, current proxmox.Service) bool {
+	if isServiceDown(candidate) != isServiceDown(current) {
+		return !isServiceDown(candidate)
+	}
+	return len(candidate.IPs) > len(current.IPs)
+}
+
+func findService(services []proxmox.Service, vmID uint64) proxmox.Service {
+	for _, service := range services {
+		if service.ID == vmID {
+			return service
+		}
+	}
+	return proxmox.Service{}
+}
+
+func getIPsOfService(client *proxmox.ProxmoxClient, ctx context.Context, nodeName string, vmID uint64, isContainer bool, opts DiscoveryOptions, labels map[string]string) (ips []proxmox.IP, err error) {
+	var agentInterfaces *proxmox.ParsedAgentInterfaces
+	if isContainer && opts.LegacyContainerIPs {
+		// Without the interfaces endpoint the static addresses of the container are used.
+		return nil, nil
+	}
 	if isContainer {
 		agentInterfaces, err = client.GetContainerNetworkInterfaces(ctx, nodeName, vmID)
 		if err != nil {
@@ -58,88 +218,183 @@ func getIPsOfService(client *internal.ProxmoxClient, ctx context.Context, nodeNa
 
 	rawIPs := agentInterfaces.GetIPs()
 
-	filteredIPs := make([]internal.IP, 0)
-	for _, ip := range rawIPs {
-		if (ip.AddressType == "ipv4" || ip.AddressType == "inet") && ip.Address != "127.0.0.1" {
-			filteredIPs = append(filteredIPs, ip)
-		}
-	}
+	filteredIPs := selectIPs(rawIPs, opts, labels)
 
-	if len(filteredIPs) == 0 && client.LogLevel == internal.LogLevelDebug {
+	if iface := guestInterface(labels, opts); len(filteredIPs) == 0 && len(rawIPs) > 0 && iface != "" {
+		log.Printf("WARNING: No usable IP found on interface %s of %s/%d", iface, nodeName, vmID)
+	}
+	if len(filteredIPs) == 0 && client.LogLevel == proxmox.LogLevelDebug {
 		log.Printf("DEBUG: No valid IPs found for %s/%d (isContainer: %t). Raw IPs were: %+v", nodeName, vmID, isContainer, rawIPs)
 	}
 
 	return filteredIPs, nil
 }
 
-func scanServices(client *internal.ProxmoxClient, ctx context.Context, nodeName string) (services []internal.Service, err error) {
+// scanServices returns the services of a node, appended to buffer[:0].
+func scanServices(client *proxmox.ProxmoxClient, ctx context.Context, nodeName string, filter *guestFilter, buffer []proxmox.Service) (services []proxmox.Service, err error) {
+	services = buffer[:0]
 	// Scan virtual machines
-	vms, err := client.GetVirtualMachines(ctx, nodeName)
-	if err != nil {
-		return nil, fmt.Errorf("error scanning VMs on node %s: %w", nodeName, err)
+	var vms []proxmox.VirtualMachine
+	if filter.opts.includesGuestType(guestTypeQemu) {
+		vms, err = client.GetVirtualMachines(ctx, nodeName)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning VMs on node %s: %w", nodeName, err)
+		}
 	}
 
 	for _, vm := range vms {
 		log.Printf("Scanning VM %s/%s (%d): %s", nodeName, vm.Name, vm.VMID, vm.Status)
+		filter.see(vm.VMID)
+
+		if ok, reason := filter.includes(guestRef{Node: nodeName, VMID: vm.VMID, Name: vm.Name, Status: vm.Status, Template: vm.IsTemplate(), Lock: vm.Lock}); !ok {
+			log.Printf("Skipping VM %s (%d) because %s", vm.Name, vm.VMID, reason)
+			continue
+		}
 
-		if vm.Status == "running" {
-			config, err := client.GetVMConfig(ctx, nodeName, vm.VMID)
+		if vm.Status == "running" || filter.opts.IncludeStopped {
+			guestCtx, span := client.Tracer.Start(ctx, "scan guest", proxmox.SpanKindInternal, guestSpanAttributes(nodeName, vm.VMID, guestTypeQemu))
+			config, err := client.GetVMConfig(guestCtx, nodeName, vm.VMID)
 			if err != nil {
 				log.Printf("Error getting VM config for %d: %v", vm.VMID, err)
+				span.RecordError(err)
+				span.End()
 				continue
 			}
 
-			configMap := config.GetTraefikMap()
+			configMap := filter.guestLabels(client, guestCtx, guestRef{Node: nodeName, VMID: vm.VMID, Name: vm.Name, Status: vm.Status, Uptime: vm.Uptime}, config)
+			tags := config.GetTags()
 
-			if configMap["traefik.enable"] != "true" {
+			if ok, reason := filter.includesTags(tags); !ok {
+				log.Printf("Skipping VM %s (%d) because %s", vm.Name, vm.VMID, reason)
+				span.End()
+				continue
+			}
+
+			if !filter.isEnabled(configMap, tags) {
 				log.Printf("Skipping VM %s (%d) because traefik.enable is not true", vm.Name, vm.VMID)
+				span.End()
+				continue
+			}
+
+			if filter.opts.RequireAgentPing && vm.Status == "running" {
+				err := filter.opts.AgentBackoff.query(vm.VMID, vm.Uptime, func() error {
+					return client.PingVMAgent(guestCtx, nodeName, vm.VMID)
+				})
+				if err != nil {
+					log.Printf("Skipping VM %s (%d) because its guest agent doesn't answer: %v", vm.Name, vm.VMID, err)
+					span.End()
+					continue
+				}
+			}
+
+			// The failures of a command say nothing about the agent, they aren't recorded.
+			if filter.opts.PublishAll && vm.Status == "running" && filter.opts.AgentBackoff.allows(vm.VMID, vm.Uptime) {
+				configMap = withPublishedPorts(client, guestCtx, nodeName, vm.VMID, serviceID(vm.Name, vm.VMID, configMap), configMap)
 			}
 
 			log.Printf("VM %s (%d) traefik config: %v", vm.Name, vm.VMID, configMap)
 
-			service := internal.NewService(vm.VMID, vm.Name, configMap)
+			service := proxmox.NewService(vm.VMID, vm.Name, configMap)
+			service.Certificates = filter.guestCertificates(client, guestCtx, vm.VMID, configMap)
 
-			ips, err := getIPsOfService(client, ctx, nodeName, vm.VMID, false)
-			if err == nil {
-				service.IPs = ips
+			service.Status = vm.Status
+			service.Domain = filter.domain(guestRef{Node: nodeName, VMID: vm.VMID})
+			if filter.opts.UseGuestHostname && vm.Status == "running" {
+				var hostname string
+				err := filter.opts.AgentBackoff.query(vm.VMID, vm.Uptime, func() (err error) {
+					hostname, err = client.GetVMHostname(guestCtx, nodeName, vm.VMID)
+					return err
+				})
+				if err != nil {
+					log.Printf("Error getting hostname of VM %s (%d), using its name: %v", vm.Name, vm.VMID, err)
+				}
+				service.Hostname = hostname
 			}
 
+			if vm.Status == "running" && configMap[ipLabel] == "" {
+				service.IPs = filter.opts.IPCache.agentIPs(vm.VMID, func() (ips []proxmox.IP, err error) {
+					err = filter.opts.AgentBackoff.query(vm.VMID, vm.Uptime, func() (err error) {
+						ips, err = getIPsOfService(client, guestCtx, nodeName, vm.VMID, false, filter.opts, configMap)
+						return err
+					})
+					return ips, err
+				})
+				if len(service.IPs) == 0 {
+					service.IPs = fallbackIPs(guestCtx, config, vm.VMID, filter.opts, configMap)
+				}
+				service.IPs = filter.opts.IPCache.resolve(vm.VMID, vm.Lock, service.IPs)
+			}
+			span.End()
+
 			services = append(services, service)
 		}
 	}
 
 	// Scan containers
-	cts, err := client.GetContainers(ctx, nodeName)
-	if err != nil {
-		return nil, fmt.Errorf("error scanning containers on node %s: %w", nodeName, err)
+	var cts []proxmox.Container
+	if filter.opts.includesGuestType(guestTypeLXC) {
+		cts, err = client.GetContainers(ctx, nodeName)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning containers on node %s: %w", nodeName, err)
+		}
 	}
 
 	for _, ct := range cts {
 		log.Printf("Scanning container %s/%s (%d): %s", nodeName, ct.Name, ct.VMID, ct.Status)
+		filter.see(ct.VMID)
+
+		if ok, reason := filter.includes(guestRef{Node: nodeName, VMID: ct.VMID, Name: ct.Name, Status: ct.Status, IsContainer: true, Template: ct.IsTemplate(), Lock: ct.Lock}); !ok {
+			log.Printf("Skipping container %s (%d) because %s", ct.Name, ct.VMID, reason)
+			continue
+		}
 
-		if ct.Status == "running" {
-			config, err := client.GetContainerConfig(ctx, nodeName, ct.VMID)
+		if ct.Status == "running" || filter.opts.IncludeStopped {
+			guestCtx, span := client.Tracer.Start(ctx, "scan guest", proxmox.SpanKindInternal, guestSpanAttributes(nodeName, ct.VMID, guestTypeLXC))
+			config, err := client.GetContainerConfig(guestCtx, nodeName, ct.VMID)
 			if err != nil {
 				log.Printf("Error getting container config for %d: %v", ct.VMID, err)
+				span.RecordError(err)
+				span.End()
 				continue
 			}
 
-			configMap := config.GetTraefikMap()
+			configMap := filter.guestLabels(client, guestCtx, guestRef{Node: nodeName, VMID: ct.VMID, Name: ct.Name, Status: ct.Status, IsContainer: true}, config)
+			tags := config.GetTags()
+
+			if ok, reason := filter.includesTags(tags); !ok {
+				log.Printf("Skipping container %s (%d) because %s", ct.Name, ct.VMID, reason)
+				span.End()
+				continue
+			}
 
-			if configMap["traefik.enable"] != "true" {
+			if !filter.isEnabled(configMap, tags) {
 				log.Printf("Skipping container %s (%d) because traefik.enable is not true", ct.Name, ct.VMID)
+				span.End()
 				continue
 			}
 
 			log.Printf("Container %s (%d) traefik config: %v", ct.Name, ct.VMID, configMap)
 
-			service := internal.NewService(ct.VMID, ct.Name, configMap)
+			service := proxmox.NewService(ct.VMID, ct.Name, configMap)
+			service.Certificates = filter.guestCertificates(client, guestCtx, ct.VMID, configMap)
+
+			service.Status = ct.Status
+			service.Domain = filter.domain(guestRef{Node: nodeName, VMID: ct.VMID, IsContainer: true})
+			if filter.opts.UseGuestHostname {
+				service.Hostname = config.Hostname
+			}
 
 			// Try to get container IPs if possible
-			ips, err := getIPsOfService(client, ctx, nodeName, ct.VMID, true)
-			if err == nil {
-				service.IPs = ips
+			if ct.Status == "running" && configMap[ipLabel] == "" {
+				service.IPs = filter.opts.IPCache.agentIPs(ct.VMID, func() ([]proxmox.IP, error) {
+					return getIPsOfService(client, guestCtx, nodeName, ct.VMID, true, filter.opts, configMap)
+				})
+				if len(service.IPs) == 0 {
+					service.IPs = fallbackIPs(guestCtx, config, ct.VMID, filter.opts, configMap)
+				}
+				service.IPs = filter.opts.IPCache.resolve(ct.VMID, ct.Lock, service.IPs)
 			}
+			span.End()
 
 			services = append(services, service)
 		}
@@ -147,3 +402,57 @@ func scanServices(client *internal.ProxmoxClient, ctx context.Context, nodeName
 
 	return services, nil
 }
+
+func guestSpanAttributes(nodeName string, vmID uint64, guestType string) map[string]string {
+	return map[string]string{
+		"proxmox.node":       nodeName,
+		"proxmox.vmid":       strconv.FormatUint(vmID, 10),
+		"proxmox.guest_type": guestType,
+	}
+}
+
+// guestRef identifies a guest and the node it currently lives on.
+type guestRef struct {
+	Node        string
+	VMID        uint64
+	Name        string
+	Status      string
+	IsContainer bool
+	Template    bool
+	// Lock is the active lock of the guest (e.g. backup, clone, migrate), empty when unlocked.
+	Lock string
+	// Uptime is the uptime of a running VM, for the agent backoff.
+	Uptime uint64
+}
+
+// findGuest looks up a guest by VMID on all nodes of the cluster.
+func findGuest(client *proxmox.ProxmoxClient, ctx context.Context, vmID uint64) (*guestRef, error) {
+	nodes, err := client.GetNodes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error scanning nodes: %w", err)
+	}
+
+	for _, nodeStatus := range nodes {
+		vms, err := client.GetVirtualMachines(ctx, nodeStatus.Node)
+		if err != nil {
+			log.Printf("Error scanning VMs on node %s: %v", nodeStatus.Node, err)
+		}
+		for _, vm := range vms {
+			if vm.VMID == vmID {
+				return &guestRef{Node: nodeStatus.Node, VMID: vm.VMID, Name: vm.Name, Status: vm.Status, Uptime: vm.Uptime}, nil
+			}
+		}
+
+		cts, err := client.GetContainers(ctx, nodeStatus.Node)
+		if err != nil {
+			log.Printf("Error scanning containers on node %s: %v", nodeStatus.Node, err)
+		}
+		for _, ct := range cts {
+			if ct.VMID == vmID {
+				return &guestRef{Node: nodeStatus.Node, VMID: ct.VMID, Name: ct.Name, Status: ct.Status, IsContainer: true}, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("guest %d not found on any node", vmID)
+}
@@ -0,0 +1,92 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/NX211/traefik-proxmox-provider/dynamic"
+)
+
+const (
+	// proxmoxUIName names the router, service and servers transport of the Proxmox web interface.
+	proxmoxUIName = "proxmox-ui"
+	// proxmoxUIPort is the port the nodes serve the web interface and the API on.
+	proxmoxUIPort = "8006"
+	// spiceProxyPort is the port of the SPICE proxy of the nodes.
+	spiceProxyPort = "3128"
+)
+
+// buildProxmoxUIConfiguration returns the configuration exposing the web interface of the nodes: on the
+// ProxmoxUIHost, balanced between the nodes with a sticky cookie, and on <node>.<host> for every node. The
+// nodes serve it with a self-signed certificate, so it isn't verified. Nodes without a known address use
+// their name.
+//
+// With ProxmoxUIConsoles, the API paths of each node (/api2/json/nodes/<node>/...) are routed to the node
+// itself on the shared host, so the vncproxy call and the vncwebsocket of a noVNC console reach the same
+// node. With SpiceEntryPoint, a TCP router on that entry point forwards SPICE clients to the SPICE proxy
+// of the nodes.
+func buildProxmoxUIConfiguration(opts ConfigurationOptions, nodeNames []string) *dynamic.Configuration {
+	host, nodeAddresses := opts.ProxmoxUIHost, opts.NodeAddresses
+	config := newConfiguration()
+	config.HTTP.ServersTransports = map[string]*dynamic.ServersTransport{
+		proxmoxUIName: {InsecureSkipVerify: true},
+	}
+	passHostHeader := true
+
+	cluster := &dynamic.ServersLoadBalancer{
+		Sticky:           &dynamic.Sticky{Cookie: &dynamic.Cookie{Name: proxmoxUIName, Secure: true, HTTPOnly: true}},
+		PassHostHeader:   &passHostHeader,
+		ServersTransport: proxmoxUIName,
+	}
+	// The SPICE proxy of any node forwards to the node of the guest.
+	spice := &dynamic.TCPServersLoadBalancer{}
+	for _, nodeName := range nodeNames {
+		address := nodeAddresses[nodeName]
+		if address == "" {
+			address = nodeName
+		}
+		server := dynamic.Server{URL: "https://" + net.JoinHostPort(address, proxmoxUIPort)}
+		cluster.Servers = append(cluster.Servers, server)
+
+		name := fmt.Sprintf("%s-%s", proxmoxUIName, sanitizeName(nodeName))
+		config.HTTP.Routers[name] = &dynamic.Router{
+			Rule:    fmt.Sprintf("Host(`%s.%s`)", sanitizeHost(nodeName), host),
+			Service: name,
+		}
+		config.HTTP.Services[name] = &dynamic.Service{LoadBalancer: &dynamic.ServersLoadBalancer{
+			Servers:          []dynamic.Server{server},
+			PassHostHeader:   &passHostHeader,
+			ServersTransport: proxmoxUIName,
+		}}
+		if opts.ProxmoxUIConsoles {
+			config.HTTP.Routers[name+"-api"] = &dynamic.Router{
+				Rule:    fmt.Sprintf("Host(`%s`) && PathPrefix(`/api2/json/nodes/%s/`)", host, nodeName),
+				Service: name,
+			}
+		}
+		if opts.SpiceEntryPoint != "" {
+			spice.Servers = append(spice.Servers, dynamic.TCPServer{Address: net.JoinHostPort(address, spiceProxyPort)})
+		}
+	}
+	if len(spice.Servers) > 0 {
+		spiceName := proxmoxUIName + "-spice"
+		config.TCP.Routers[spiceName] = &dynamic.TCPRouter{
+			EntryPoints: []string{opts.SpiceEntryPoint},
+			Rule:        "HostSNI(`*`)",
+			Service:     spiceName,
+		}
+		config.TCP.Services[spiceName] = &dynamic.TCPService{LoadBalancer: spice}
+	}
+	if len(cluster.Servers) == 0 {
+		log.Printf("WARNING: No node to expose the Proxmox web interface on %s", host)
+		return config
+	}
+
+	config.HTTP.Routers[proxmoxUIName] = &dynamic.Router{
+		Rule:    fmt.Sprintf("Host(`%s`)", host),
+		Service: proxmoxUIName,
+	}
+	config.HTTP.Services[proxmoxUIName] = &dynamic.Service{LoadBalancer: cluster}
+	return config
+}
@@ -0,0 +1,96 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// internalServer is the optional HTTP listener exposing provider internals.
+type internalServer struct {
+	server *http.Server
+}
+
+func newInternalServer(address string, metrics *providerMetrics, status *providerStatus) *internalServer {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if _, err := metrics.WriteTo(rw); err != nil {
+			log.Printf("Error writing metrics: %v", err)
+		}
+	})
+	mux.HandleFunc("/status", func(rw http.ResponseWriter, _ *http.Request) {
+		writeJSON(rw, http.StatusOK, status.report(time.Now()))
+	})
+	mux.HandleFunc("/config", func(rw http.ResponseWriter, _ *http.Request) {
+		configuration := status.lastConfiguration()
+		if configuration == nil {
+			http.Error(rw, "no configuration generated yet", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(rw, http.StatusOK, redactPrivateKeys(configuration))
+	})
+	mux.HandleFunc("/health", func(rw http.ResponseWriter, _ *http.Request) {
+		report := status.report(time.Now())
+		writeProbe(rw, report.Healthy)
+	})
+	mux.HandleFunc("/ready", func(rw http.ResponseWriter, _ *http.Request) {
+		report := status.report(time.Now())
+		writeProbe(rw, report.Ready)
+	})
+
+	return &internalServer{
+		server: &http.Server{
+			Addr:              address,
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		},
+	}
+}
+
+// start binds the listener and serves requests in the background.
+func (s *internalServer) start() error {
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Internal HTTP listener started on %s", listener.Addr())
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Internal HTTP listener stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// stop gracefully shuts the listener down.
+func (s *internalServer) stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+func writeJSON(rw http.ResponseWriter, statusCode int, value interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(statusCode)
+
+	encoder := json.NewEncoder(rw)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		log.Printf("Error writing JSON response: %v", err)
+	}
+}
+
+func writeProbe(rw http.ResponseWriter, ok bool) {
+	if !ok {
+		http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = rw.Write([]byte("ok"))
+}
@@ -0,0 +1,128 @@
+package provider
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NX211/traefik-proxmox-provider/dynamic"
+)
+
+// nodeState describes the outcome of the last scan of a single node.
+type nodeState struct {
+	Name      string `json:"name"`
+	Reachable bool   `json:"reachable"`
+	Guests    int    `json:"guests"`
+	Error     string `json:"error,omitempty"`
+}
+
+// statusReport is the JSON document served on the status endpoint.
+type statusReport struct {
+	Healthy            bool         `json:"healthy"`
+	Ready              bool         `json:"ready"`
+	LastPoll           *time.Time   `json:"lastPoll,omitempty"`
+	LastSuccessfulPoll *time.Time   `json:"lastSuccessfulPoll,omitempty"`
+	LastError          string       `json:"lastError,omitempty"`
+	Guests             int          `json:"guests"`
+	Nodes              []nodeState  `json:"nodes"`
+	GuestErrors        []GuestError `json:"guestErrors,omitempty"`
+}
+
+// providerStatus keeps track of the provider health between polls.
+type providerStatus struct {
+	mu sync.RWMutex
+
+	// maxAge is how long a successful poll keeps the provider healthy.
+	maxAge time.Duration
+
+	lastPoll    time.Time
+	lastSuccess time.Time
+	lastError   string
+	nodes       map[string]nodeState
+
+	// configuration is the last dynamic configuration sent to Traefik.
+	configuration *dynamic.Configuration
+	// guestErrors are the configuration errors of guests found while generating it.
+	guestErrors []GuestError
+}
+
+func newProviderStatus(pollInterval time.Duration) *providerStatus {
+	return &providerStatus{
+		maxAge: 3 * pollInterval,
+		nodes:  make(map[string]nodeState),
+	}
+}
+
+// recordPoll stores the result of a discovery pass.
+func (s *providerStatus) recordPoll(now time.Time, guests map[string]int, nodeErrors map[string]error, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastPoll = now
+	if err != nil {
+		s.lastError = err.Error()
+		return
+	}
+
+	s.lastSuccess = now
+	s.lastError = ""
+	s.nodes = make(map[string]nodeState, len(guests)+len(nodeErrors))
+	for nodeName, count := range guests {
+		s.nodes[nodeName] = nodeState{Name: nodeName, Reachable: true, Guests: count}
+	}
+	for nodeName, nodeErr := range nodeErrors {
+		s.nodes[nodeName] = nodeState{Name: nodeName, Reachable: false, Error: nodeErr.Error()}
+	}
+}
+
+// recordConfiguration stores the configuration that was just sent to Traefik.
+func (s *providerStatus) recordConfiguration(configuration *dynamic.Configuration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.configuration = configuration
+}
+
+// recordGuestErrors stores the configuration errors of the guests of the last discovery pass.
+func (s *providerStatus) recordGuestErrors(guestErrors []GuestError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.guestErrors = guestErrors
+}
+
+// lastConfiguration returns the last configuration sent to Traefik, or nil before the first push.
+func (s *providerStatus) lastConfiguration() *dynamic.Configuration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.configuration
+}
+
+// report builds a snapshot of the current status.
+func (s *providerStatus) report(now time.Time) statusReport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	report := statusReport{
+		Ready:       !s.lastSuccess.IsZero(),
+		LastError:   s.lastError,
+		Nodes:       make([]nodeState, 0, len(s.nodes)),
+		GuestErrors: s.guestErrors,
+	}
+	report.Healthy = report.Ready && now.Sub(s.lastSuccess) <= s.maxAge
+
+	if !s.lastPoll.IsZero() {
+		lastPoll := s.lastPoll
+		report.LastPoll = &lastPoll
+	}
+	if !s.lastSuccess.IsZero() {
+		lastSuccess := s.lastSuccess
+		report.LastSuccessfulPoll = &lastSuccess
+	}
+
+	for _, node := range s.nodes {
+		report.Guests += node.Guests
+		report.Nodes = append(report.Nodes, node)
+	}
+	sort.Slice(report.Nodes, func(i, j int) bool { return report.Nodes[i].Name < report.Nodes[j].Name })
+
+	return report
+}
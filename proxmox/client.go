@@ -0,0 +1,476 @@
+// Package proxmox is a small client for the parts of the Proxmox VE API used to discover
+// virtual machines and containers, together with the models it returns.
+package proxmox
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv" // Added import
+	"strings"
+	"time"
+)
+
+// Log levels
+const (
+	LogLevelInfo  = "info"
+	LogLevelDebug = "debug"
+)
+
+// RequestObserver is notified after every API request performed by the client
+type RequestObserver func(method, path string, duration time.Duration, err error)
+
+// ProxmoxClient represents a client to the Proxmox API
+type ProxmoxClient struct {
+	BaseURL     string
+	TokenID     string
+	Token       string
+	HTTPClient  *http.Client
+	LogLevel    string
+	ValidateSSL bool
+	Observer    RequestObserver
+	Tracer      *Tracer
+	// AgentTimeout bounds the calls answered by the QEMU guest agent of a VM, so a hung agent doesn't hold
+	// the scan for the whole timeout of the HTTPClient. Zero only applies the timeout of the HTTPClient.
+	AgentTimeout time.Duration
+}
+
+// NewProxmoxClient creates a new Proxmox API client
+func NewProxmoxClient(apiEndpoint, tokenID, token string, validateSSL bool, logLevel string) *ProxmoxClient {
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: !validateSSL,
+			},
+		},
+		Timeout: 30 * time.Second,
+	}
+
+	baseURL := apiBaseURL(apiEndpoint)
+	if logLevel == LogLevelDebug {
+		log.Printf("Creating new Proxmox client with base URL: %s", baseURL)
+	}
+
+	return &ProxmoxClient{
+		BaseURL:     baseURL,
+		TokenID:     tokenID,
+		Token:       token,
+		HTTPClient:  httpClient,
+		LogLevel:    logLevel,
+		ValidateSSL: validateSSL,
+	}
+}
+
+// apiBaseURL returns the base URL of the API for an endpoint, which may include a port and the path prefix
+// of a reverse proxy, e.g. https://gateway.example.com/proxmox. Trailing slashes and /api2/json are ignored.
+func apiBaseURL(apiEndpoint string) string {
+	endpoint := strings.TrimRight(apiEndpoint, "/")
+	endpoint = strings.TrimSuffix(endpoint, "/api2/json")
+	return endpoint + "/api2/json"
+}
+
+// Do performs an HTTP request to the Proxmox API
+func (c *ProxmoxClient) Do(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+	ctx, span := c.Tracer.Start(ctx, apiSpanName(method, path), SpanKindClient, apiSpanAttributes(method, path))
+	defer span.End()
+
+	start := time.Now()
+	err := c.do(ctx, method, path, body, result)
+	span.RecordError(err)
+	if c.Observer != nil {
+		c.Observer(method, path, time.Since(start), err)
+	}
+	return err
+}
+
+func (c *ProxmoxClient) do(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+	fullURL := c.BaseURL + path
+
+	if c.LogLevel == LogLevelDebug {
+		log.Printf("API Request: %s %s", method, fullURL)
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		jsonBody, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request body: %w", err)
+		}
+		reqBody = bytes.NewBuffer(jsonBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, fullURL, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set required headers
+	req.Header.Set("Authorization", fmt.Sprintf("PVEAPIToken=%s=%s", c.TokenID, c.Token))
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	SpanFromContext(ctx).SetAttribute("http.response.status_code", strconv.Itoa(resp.StatusCode))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	if result != nil {
+		respBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read response body: %w", err)
+		}
+
+		if c.LogLevel == LogLevelDebug {
+			log.Printf("API Response: %s", string(respBody))
+		}
+
+		err = json.Unmarshal(respBody, result)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal response: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// agentContext bounds ctx by the AgentTimeout.
+func (c *ProxmoxClient) agentContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.AgentTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.AgentTimeout)
+}
+
+// agentDo performs a request to a guest agent, bounded by the AgentTimeout.
+func (c *ProxmoxClient) agentDo(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+	ctx, cancel := c.agentContext(ctx)
+	defer cancel()
+	return c.Do(ctx, method, path, body, result)
+}
+
+// Get performs a GET request to the Proxmox API
+func (c *ProxmoxClient) Get(ctx context.Context, path string, result interface{}) error {
+	return c.Do(ctx, http.MethodGet, path, nil, result)
+}
+
+// GetVersion retrieves the Proxmox version
+func (c *ProxmoxClient) GetVersion(ctx context.Context) (*Version, error) {
+	var response struct {
+		Data Version `json:"data"`
+	}
+	err := c.Get(ctx, "/version", &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response.Data, nil
+}
+
+// GetNodes retrieves all nodes in the Proxmox cluster
+func (c *ProxmoxClient) GetNodes(ctx context.Context) ([]NodeStatus, error) {
+	var response struct {
+		Data []NodeStatus `json:"data"`
+	}
+	err := c.Get(ctx, "/nodes", &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
+
+// GetClusterResources retrieves the cluster resources of the given type (vm, node, storage, ...)
+func (c *ProxmoxClient) GetClusterResources(ctx context.Context, resourceType string) ([]ClusterResource, error) {
+	var response struct {
+		Data []ClusterResource `json:"data"`
+	}
+	err := c.Get(ctx, fmt.Sprintf("/cluster/resources?type=%s", resourceType), &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
+
+// GetClusterStatus retrieves the status of the cluster and its nodes
+func (c *ProxmoxClient) GetClusterStatus(ctx context.Context) ([]ClusterStatus, error) {
+	var response struct {
+		Data []ClusterStatus `json:"data"`
+	}
+	err := c.Get(ctx, "/cluster/status", &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
+
+// GetHANodeStatus retrieves the state of every node as seen by the HA manager (online, maintenance, ...)
+func (c *ProxmoxClient) GetHANodeStatus(ctx context.Context) (map[string]string, error) {
+	var response struct {
+		Data struct {
+			ManagerStatus struct {
+				NodeStatus map[string]string `json:"node_status"`
+			} `json:"manager_status"`
+		} `json:"data"`
+	}
+	err := c.Get(ctx, "/cluster/ha/status/manager_status", &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data.ManagerStatus.NodeStatus, nil
+}
+
+// GetPools retrieves the resource pools
+func (c *ProxmoxClient) GetPools(ctx context.Context) ([]Pool, error) {
+	var response struct {
+		Data []Pool `json:"data"`
+	}
+	err := c.Get(ctx, "/pools", &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
+
+// GetStorage retrieves the configuration of a storage
+func (c *ProxmoxClient) GetStorage(ctx context.Context, storage string) (*Storage, error) {
+	var response struct {
+		Data Storage `json:"data"`
+	}
+	err := c.Get(ctx, fmt.Sprintf("/storage/%s", url.PathEscape(storage)), &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response.Data, nil
+}
+
+// GetSDNIPAMStatus retrieves the addresses allocated by an SDN IPAM (e.g. the built-in "pve" IPAM)
+func (c *ProxmoxClient) GetSDNIPAMStatus(ctx context.Context, ipam string) ([]IPAMEntry, error) {
+	var response struct {
+		Data []IPAMEntry `json:"data"`
+	}
+	err := c.Get(ctx, fmt.Sprintf("/cluster/sdn/ipams/%s/status", url.PathEscape(ipam)), &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
+
+// GetVirtualMachines retrieves all VMs on a node
+func (c *ProxmoxClient) GetVirtualMachines(ctx context.Context, nodeName string) ([]VirtualMachine, error) {
+	var response struct {
+		Data []VirtualMachine `json:"data"`
+	}
+	err := c.Get(ctx, fmt.Sprintf("/nodes/%s/qemu", nodeName), &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
+
+// GetContainers retrieves all containers on a node
+func (c *ProxmoxClient) GetContainers(ctx context.Context, nodeName string) ([]Container, error) {
+	var response struct {
+		Data []Container `json:"data"`
+	}
+	err := c.Get(ctx, fmt.Sprintf("/nodes/%s/lxc", nodeName), &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
+
+// GetVMConfig retrieves the configuration of a VM
+func (c *ProxmoxClient) GetVMConfig(ctx context.Context, nodeName string, vmID uint64) (*ParsedConfig, error) {
+	var response struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	err := c.Get(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/config", nodeName, vmID), &response)
+	if err != nil {
+		return nil, err
+	}
+	return NewParsedConfig(response.Data), nil
+}
+
+// GetContainerConfig retrieves the configuration of a container
+func (c *ProxmoxClient) GetContainerConfig(ctx context.Context, nodeName string, vmID uint64) (*ParsedConfig, error) {
+	var response struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	err := c.Get(ctx, fmt.Sprintf("/nodes/%s/lxc/%d/config", nodeName, vmID), &response)
+	if err != nil {
+		return nil, err
+	}
+	return NewParsedConfig(response.Data), nil
+}
+
+// GetVMNetworkInterfaces retrieves network interfaces from a VM using the QEMU guest agent
+func (c *ProxmoxClient) GetVMNetworkInterfaces(ctx context.Context, nodeName string, vmID uint64) (*ParsedAgentInterfaces, error) {
+	ctx, cancel := c.agentContext(ctx)
+	defer cancel()
+
+	var response struct {
+		Data ParsedAgentInterfaces `json:"data"`
+	}
+	err := c.Get(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/agent/network-get-interfaces", nodeName, vmID), &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response.Data, nil
+}
+
+// GetVMHostname retrieves the hostname of a VM using the QEMU guest agent
+func (c *ProxmoxClient) GetVMHostname(ctx context.Context, nodeName string, vmID uint64) (string, error) {
+	ctx, cancel := c.agentContext(ctx)
+	defer cancel()
+
+	var response struct {
+		Data struct {
+			Result struct {
+				HostName string `json:"host-name"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	err := c.Get(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/agent/get-host-name", nodeName, vmID), &response)
+	if err != nil {
+		return "", err
+	}
+	return response.Data.Result.HostName, nil
+}
+
+// PingVMAgent checks that the QEMU guest agent of a VM answers
+func (c *ProxmoxClient) PingVMAgent(ctx context.Context, nodeName string, vmID uint64) error {
+	ctx, cancel := c.agentContext(ctx)
+	defer cancel()
+
+	return c.Do(ctx, http.MethodPost, fmt.Sprintf("/nodes/%s/qemu/%d/agent/ping", nodeName, vmID), nil, nil)
+}
+
+// ReadVMFile reads a file inside a VM using the QEMU guest agent
+func (c *ProxmoxClient) ReadVMFile(ctx context.Context, nodeName string, vmID uint64, path string) (string, error) {
+	ctx, cancel := c.agentContext(ctx)
+	defer cancel()
+
+	var response struct {
+		Data struct {
+			Content string `json:"content"`
+		} `json:"data"`
+	}
+	err := c.Get(ctx, fmt.Sprintf("/nodes/%s/qemu/%d/agent/file-read?file=%s", nodeName, vmID, url.QueryEscape(path)), &response)
+	if err != nil {
+		return "", err
+	}
+	return response.Data.Content, nil
+}
+
+// ExecVMCommand runs a command inside a VM using the QEMU guest agent and returns its output once it exited.
+// Each request to the agent is bounded by the AgentTimeout, the command itself only by ctx.
+func (c *ProxmoxClient) ExecVMCommand(ctx context.Context, nodeName string, vmID uint64, command []string) (string, error) {
+	var started struct {
+		Data struct {
+			PID int `json:"pid"`
+		} `json:"data"`
+	}
+	err := c.agentDo(ctx, http.MethodPost, fmt.Sprintf("/nodes/%s/qemu/%d/agent/exec", nodeName, vmID), map[string]interface{}{"command": command}, &started)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		var status struct {
+			Data struct {
+				// Exited is 1 once the command exited, older releases render it as a JSON boolean
+				Exited   json.RawMessage `json:"exited"`
+				ExitCode int             `json:"exitcode"`
+				OutData  string          `json:"out-data"`
+				ErrData  string          `json:"err-data"`
+			} `json:"data"`
+		}
+		err := c.agentDo(ctx, http.MethodGet, fmt.Sprintf("/nodes/%s/qemu/%d/agent/exec-status?pid=%d", nodeName, vmID, started.Data.PID), nil, &status)
+		if err != nil {
+			return "", err
+		}
+		if exited := string(status.Data.Exited); exited == "1" || exited == "true" {
+			if status.Data.ExitCode != 0 {
+				return status.Data.OutData, fmt.Errorf("command exited with code %d: %s", status.Data.ExitCode, status.Data.ErrData)
+			}
+			return status.Data.OutData, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// GetContainerNetworkInterfaces retrieves network interfaces from a container
+func (c *ProxmoxClient) GetContainerNetworkInterfaces(ctx context.Context, nodeName string, vmID uint64) (*ParsedAgentInterfaces, error) {
+	var response struct {
+		Data []struct {
+			Name            string `json:"name"`
+			HardwareAddress string `json:"hardware-address"`
+			Inet            string `json:"inet"`
+			Inet6           string `json:"inet6"`
+			IPAddresses     []struct {
+				Address     string      `json:"ip-address,omitempty"`
+				AddressType string      `json:"ip-address-type,omitempty"`
+				Prefix      json.Number `json:"prefix,omitempty"` // Use json.Number
+			} `json:"ip-addresses"`
+			HWAddr string `json:"hwaddr"`
+		} `json:"data"`
+	}
+	err := c.Get(ctx, fmt.Sprintf("/nodes/%s/lxc/%d/interfaces", nodeName, vmID), &response)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ParsedAgentInterfaces{
+		Result: make([]AgentInterface, 0),
+	}
+
+	for _, iface := range response.Data {
+		var ips []IP
+		if len(iface.IPAddresses) == 0 {
+			// Some releases only report the primary addresses as CIDRs.
+			ips = append(ips, staticIPs(map[string]string{"ip": iface.Inet, "ip6": iface.Inet6}, iface.Name)...)
+		}
+		for _, ip := range iface.IPAddresses {
+			prefixUint, err := strconv.ParseUint(ip.Prefix.String(), 10, 64) // Changed to use strconv.ParseUint
+			if err != nil {
+				// Log error but continue, as some IPs might be valid
+				if c.LogLevel == LogLevelDebug {
+					log.Printf("DEBUG: Failed to parse prefix string '%s' to uint64 for IP %s: %v", ip.Prefix.String(), ip.Address, err)
+				}
+				continue
+			}
+			ips = append(ips, IP{
+				Address:     ip.Address,
+				AddressType: ip.AddressType,
+				Prefix:      prefixUint,
+			})
+		}
+
+		result.Result = append(result.Result, AgentInterface{
+			Name:            iface.Name,
+			HardwareAddress: iface.HardwareAddress,
+			IPAddresses:     ips,
+		})
+	}
+
+	return result, nil
+}
@@ -0,0 +1,548 @@
+package proxmox
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/NX211/traefik-proxmox-provider/dynamic"
+	"github.com/NX211/traefik-proxmox-provider/dynamic/tls"
+)
+
+// ParsedConfig holds the parts of a VM or container configuration used by the provider
+type ParsedConfig struct {
+	Description string `json:"description,omitempty"`
+	Tags        string `json:"tags,omitempty"`
+	// Hostname is the hostname set on a container
+	Hostname string `json:"hostname,omitempty"`
+	// Net holds the network devices (net0, net1, ...) of the guest
+	Net map[string]string `json:"-"`
+	// IPConfig holds the cloud-init network settings (ipconfig0, ipconfig1, ...) of a VM
+	IPConfig map[string]string `json:"-"`
+}
+
+// NewParsedConfig builds a ParsedConfig from the raw configuration returned by the API
+func NewParsedConfig(raw map[string]interface{}) *ParsedConfig {
+	pc := &ParsedConfig{}
+	for key, value := range raw {
+		text, ok := value.(string)
+		if !ok {
+			continue
+		}
+		switch {
+		case key == "description":
+			pc.Description = text
+		case key == "tags":
+			pc.Tags = text
+		case key == "hostname":
+			pc.Hostname = text
+		case isNumberedKey(key, "net"):
+			if pc.Net == nil {
+				pc.Net = make(map[string]string)
+			}
+			pc.Net[key] = text
+		case isNumberedKey(key, "ipconfig"):
+			if pc.IPConfig == nil {
+				pc.IPConfig = make(map[string]string)
+			}
+			pc.IPConfig[key] = text
+		}
+	}
+	return pc
+}
+
+// GetStaticIPs returns the addresses statically configured on the network devices of a container
+// or in the cloud-init settings of a VM (ip= and ip6= options), skipping dhcp, auto and manual settings
+func (pc *ParsedConfig) GetStaticIPs() []IP {
+	ips := make([]IP, 0)
+	for _, key := range sortedKeys(pc.Net) {
+		options := ParseNetworkOptions(pc.Net[key])
+		ips = append(ips, staticIPs(options, options["name"])...)
+	}
+	for _, key := range sortedKeys(pc.IPConfig) {
+		// The interface name inside the VM isn't known, only the matching netN device.
+		ips = append(ips, staticIPs(ParseNetworkOptions(pc.IPConfig[key]), "")...)
+	}
+	return ips
+}
+
+// GetMACAddresses returns the MAC addresses of the network devices, lowercased, mapped to the
+// interface name set on container devices (empty for VMs)
+func (pc *ParsedConfig) GetMACAddresses() map[string]string {
+	macs := make(map[string]string)
+	for _, device := range pc.Net {
+		options := ParseNetworkOptions(device)
+		for key, value := range options {
+			// Containers use hwaddr=, VMs use the NIC model as key (virtio=, e1000=, ...).
+			if key == "name" || !isMACAddress(value) {
+				continue
+			}
+			macs[strings.ToLower(value)] = options["name"]
+		}
+	}
+	return macs
+}
+
+func isMACAddress(value string) bool {
+	mac, err := net.ParseMAC(value)
+	return err == nil && len(mac) == 6
+}
+
+// ParseNetworkOptions splits a property string such as "name=eth0,bridge=vmbr0,ip=10.0.0.5/24"
+func ParseNetworkOptions(value string) map[string]string {
+	options := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		key, val, _ := strings.Cut(part, "=")
+		options[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	return options
+}
+
+func staticIPs(options map[string]string, iface string) []IP {
+	var ips []IP
+	for _, option := range []struct{ key, addressType string }{{"ip", "ipv4"}, {"ip6", "ipv6"}} {
+		value := options[option.key]
+		switch value {
+		case "", "dhcp", "auto", "manual":
+			continue
+		}
+		address, prefix, _ := strings.Cut(value, "/")
+		prefixLength, _ := strconv.ParseUint(prefix, 10, 64)
+		ips = append(ips, IP{Address: address, AddressType: option.addressType, Prefix: prefixLength, Interface: iface})
+	}
+	return ips
+}
+
+// isNumberedKey reports whether key is prefix followed by a number, e.g. net0
+func isNumberedKey(key, prefix string) bool {
+	if !strings.HasPrefix(key, prefix) || len(key) == len(prefix) {
+		return false
+	}
+	_, err := strconv.Atoi(key[len(prefix):])
+	return err == nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ParsedAgentInterfaces holds the network interfaces reported by a guest
+type ParsedAgentInterfaces struct {
+	Result []AgentInterface `json:"result"`
+}
+
+// AgentInterface is a single network interface reported by a guest
+type AgentInterface struct {
+	Name            string `json:"name"`
+	HardwareAddress string `json:"hardware-address"`
+	IPAddresses     []IP   `json:"ip-addresses"`
+}
+
+// ContainerNetworkInterface is a network interface of a container
+type ContainerNetworkInterface struct {
+	Name            string `json:"name"`
+	HardwareAddress string `json:"hardware-address"`
+	Inet            string `json:"inet"`
+	Inet6           string `json:"inet6"`
+	IPAddresses     []IP   `json:"ip-addresses"`
+}
+
+// Node is a cluster node
+type Node struct {
+	ID     string `json:"id,omitempty"`
+	Name   string `json:"node,omitempty"`
+	Status string `json:"status,omitempty"`
+}
+
+// NodeStatus is an entry of the node list
+type NodeStatus struct {
+	Node string `json:"node"`
+}
+
+// VirtualMachine is a QEMU guest as listed on a node
+type VirtualMachine struct {
+	VMID     uint64      `json:"vmid"`
+	Name     string      `json:"name"`
+	Status   string      `json:"status"`
+	Template interface{} `json:"template,omitempty"`
+	Lock     string      `json:"lock,omitempty"`
+	Uptime   uint64      `json:"uptime,omitempty"`
+}
+
+// Container is an LXC guest as listed on a node
+type Container struct {
+	VMID     uint64 `json:"vmid"`
+	Name     string
+	Status   string      `json:"status"`
+	Template interface{} `json:"template,omitempty"`
+	Lock     string      `json:"lock,omitempty"`
+}
+
+// IsTemplate reports whether the VM is a template
+func (vm VirtualMachine) IsTemplate() bool {
+	return isFlagSet(vm.Template)
+}
+
+// IsTemplate reports whether the container is a template
+func (ct Container) IsTemplate() bool {
+	return isFlagSet(ct.Template)
+}
+
+// isFlagSet interprets a boolean the API encodes as 0/1, either as a number or a string
+func isFlagSet(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v == "1" || v == "true"
+	}
+	return false
+}
+
+// ClusterResource is an entry of the cluster resource list
+type ClusterResource struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	VMID   uint64 `json:"vmid,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Node   string `json:"node,omitempty"`
+	Status string `json:"status,omitempty"`
+	Pool   string `json:"pool,omitempty"`
+}
+
+// ClusterStatus is an entry of the cluster status, describing the cluster itself or one of its nodes
+type ClusterStatus struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	// IP is the address of a node as used by the cluster
+	IP string `json:"ip,omitempty"`
+	// Online is 1 for nodes that are members of the cluster
+	Online int `json:"online,omitempty"`
+	// Quorate is 1 when the cluster has quorum, only set on the cluster entry
+	Quorate int `json:"quorate,omitempty"`
+}
+
+// Pool is a resource pool
+type Pool struct {
+	PoolID  string `json:"poolid"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// Storage is the configuration of a Proxmox storage
+type Storage struct {
+	Storage string `json:"storage"`
+	Type    string `json:"type"`
+	// Path is the directory of file based storages, e.g. /var/lib/vz for "local"
+	Path    string `json:"path,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+// IPAMEntry is an address allocated by an SDN IPAM
+type IPAMEntry struct {
+	IP       string `json:"ip"`
+	MAC      string `json:"mac,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	VNet     string `json:"vnet,omitempty"`
+	Zone     string `json:"zone,omitempty"`
+	Subnet   string `json:"subnet,omitempty"`
+}
+
+// Version is the Proxmox VE version
+type Version struct {
+	Release string `json:"release"`
+	Version string `json:"version,omitempty"`
+}
+
+// Before reports whether the release is older than major.minor. It is false when the release can't be parsed
+func (v *Version) Before(major, minor int) bool {
+	release := v.Version
+	if release == "" {
+		release = v.Release
+	}
+	parts := strings.SplitN(release, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	gotMajor, errMajor := strconv.Atoi(parts[0])
+	gotMinor, errMinor := strconv.Atoi(parts[1])
+	if errMajor != nil || errMinor != nil {
+		return false
+	}
+	return gotMajor < major || gotMajor == major && gotMinor < minor
+}
+
+// Service is a discovered guest together with its traefik labels and IP addresses
+type Service struct {
+	ID     uint64
+	Name   string
+	IPs    []IP
+	Config map[string]string
+	// Status is the guest status reported by Proxmox, e.g. running or stopped
+	Status string
+	// Hostname is the hostname reported by the guest, used for the default Host rule when set
+	Hostname string
+	// Domain is appended to the host of the default Host rule when set
+	Domain string
+	// Draining is set when the guest's node is in maintenance, its servers should no longer receive traffic
+	Draining bool
+	// Certificates are the TLS certificates shipped by the guest
+	Certificates []*tls.CertAndStores
+	// NodeAddress is the address of the guest's node in NAT mode, its servers are reached through it
+	NodeAddress string
+	// ForwardedPorts maps the ports of the guest, e.g. 80/tcp, to the node ports forwarded to them in NAT mode
+	ForwardedPorts map[string]string
+}
+
+// IP is an address reported for a guest
+type IP struct {
+	Address     string `json:"ip-address,omitempty"`
+	AddressType string `json:"ip-address-type,omitempty"`
+	Prefix      uint64 `json:"prefix,omitempty"`
+	// Interface is the name of the network interface the address belongs to
+	Interface string `json:"interface,omitempty"`
+}
+
+// GetTraefikMap extracts the traefik.* labels from the tags and the description (notes) of a guest.
+// Label lines in the notes take precedence over ```traefik and ```traefik-json blocks, which take precedence over the tags.
+func (pc *ParsedConfig) GetTraefikMap() map[string]string {
+	m := pc.GetTagLabels()
+	text, documents := splitConfigBlocks(pc.Description, "traefik-json")
+	text, blocks := splitConfigBlocks(text, "traefik")
+	for _, block := range blocks {
+		labels, err := ParseConfigBlock(block)
+		if err != nil {
+			log.Printf("Warning: ignoring invalid traefik block in notes: %v", err)
+			continue
+		}
+		for key, value := range labels {
+			m[key] = value
+		}
+	}
+	if len(documents) > 0 {
+		m[ConfigDocumentLabel] = documents[len(documents)-1]
+	}
+	for key, value := range ParseLabels(text) {
+		m[key] = value
+	}
+	return m
+}
+
+// ConfigDocumentLabel holds a JSON document with the layout of Traefik's dynamic configuration.
+// It is set from the label itself or from a fenced ```traefik-json block of the notes.
+const ConfigDocumentLabel = "traefik.config"
+
+// splitConfigBlocks removes the blocks fenced with ```<name> from a text and returns their content.
+func splitConfigBlocks(text, name string) (string, []string) {
+	var rest, blocks []string
+	var block []string
+	inBlock := false
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case !inBlock && trimmed == "```"+name:
+			inBlock, block = true, nil
+		case inBlock && trimmed == "```":
+			inBlock = false
+			blocks = append(blocks, strings.Join(block, "\n"))
+		case inBlock:
+			block = append(block, line)
+		default:
+			rest = append(rest, line)
+		}
+	}
+	if inBlock {
+		// An unterminated block runs to the end of the notes.
+		blocks = append(blocks, strings.Join(block, "\n"))
+	}
+	return strings.Join(rest, "\n"), blocks
+}
+
+// ParseConfigBlock converts a YAML document using the layout of Traefik's dynamic configuration,
+// e.g. http.routers.<name>.rule, into the equivalent traefik.* labels.
+func ParseConfigBlock(text string) (map[string]string, error) {
+	var document interface{}
+	if err := dynamic.UnmarshalYAML([]byte(text), &document); err != nil {
+		return nil, err
+	}
+	if _, ok := document.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("expected a mapping, got %T", document)
+	}
+
+	m := make(map[string]string)
+	if err := flattenLabels(m, "traefik", document); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// flattenLabels adds the labels of a decoded YAML value: lists of scalars are joined with commas
+// and lists of mappings are indexed, e.g. traefik.http.middlewares.x.errors.status. A servers list with a
+// single entry becomes the server of the label syntax, e.g. traefik.http.services.web.loadbalancer.server.url,
+// longer ones the indexed servers, e.g. traefik.http.services.web.loadbalancer.servers[1].url.
+func flattenLabels(m map[string]string, key string, value interface{}) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			m[key] = "true"
+		}
+		for child, childValue := range v {
+			if err := flattenLabels(m, key+"."+child, childValue); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if strings.HasSuffix(strings.ToLower(key), ".servers") && len(v) == 1 {
+			return flattenLabels(m, key[:len(key)-len("servers")]+"server", v[0])
+		}
+
+		items := make([]string, 0, len(v))
+		for i, item := range v {
+			switch item.(type) {
+			case map[string]interface{}, []interface{}:
+				if err := flattenLabels(m, key+"["+strconv.Itoa(i)+"]", item); err != nil {
+					return err
+				}
+			default:
+				items = append(items, fmt.Sprintf("%v", item))
+			}
+		}
+		if len(items) > 0 {
+			m[key] = strings.Join(items, ",")
+		}
+	case nil:
+	default:
+		m[key] = fmt.Sprintf("%v", v)
+	}
+	return nil
+}
+
+// GetTagLabels extracts the traefik.* labels from the tags of a guest. Proxmox tags can't
+// contain "=", so a "+" separates the key from the value, e.g. traefik.enable+true. Unlike GetTags the
+// case is kept, so the names match those of the labels in the notes.
+func (pc *ParsedConfig) GetTagLabels() map[string]string {
+	m := make(map[string]string)
+	for _, tag := range pc.tagFields() {
+		if !strings.HasPrefix(strings.ToLower(tag), "traefik.") {
+			continue
+		}
+		key, value, found := strings.Cut(tag, "=")
+		if !found {
+			key, value, found = strings.Cut(tag, "+")
+		}
+		if found {
+			m[key] = value
+		}
+	}
+	return m
+}
+
+// ParseLabels extracts the traefik.* labels from key=value lines. Only the first "=" separates the
+// key from the value, so values may contain "=" and spaces. A value wrapped in matching double or
+// single quotes is unquoted, a line ending with "\" continues on the next line and lines starting
+// with "#" or "//" are comments.
+func ParseLabels(text string) map[string]string {
+	const separator = "="
+
+	m := make(map[string]string)
+	for _, line := range joinContinuedLines(text) {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, separator)
+		if !found {
+			continue
+		}
+
+		key = strings.Trim(key, "\" ")
+		if strings.HasPrefix(key, "traefik.") {
+			m[key] = unquoteLabelValue(strings.TrimSpace(value))
+		}
+	}
+	return m
+}
+
+// joinContinuedLines splits a text into lines, joining the lines ending with a backslash with the next one.
+func joinContinuedLines(text string) []string {
+	var lines []string
+	var current strings.Builder
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimRight(line, " \t")
+		if current.Len() > 0 {
+			trimmed = strings.TrimLeft(trimmed, " \t")
+		}
+		if strings.HasSuffix(trimmed, "\\") {
+			current.WriteString(strings.TrimSuffix(trimmed, "\\"))
+			continue
+		}
+		current.WriteString(trimmed)
+		lines = append(lines, current.String())
+		current.Reset()
+	}
+	if current.Len() > 0 {
+		lines = append(lines, current.String())
+	}
+	return lines
+}
+
+// unquoteLabelValue removes matching quotes around a value. Double quoted values may use Go escapes, e.g. \".
+func unquoteLabelValue(value string) string {
+	if len(value) < 2 {
+		return value
+	}
+	switch {
+	case value[0] == '"' && value[len(value)-1] == '"':
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			return unquoted
+		}
+		return value[1 : len(value)-1]
+	case value[0] == '\'' && value[len(value)-1] == '\'':
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// GetTags returns the Proxmox tags of the guest, lowercased
+func (pc *ParsedConfig) GetTags() []string {
+	fields := pc.tagFields()
+	tags := make([]string, 0, len(fields))
+	for _, field := range fields {
+		tags = append(tags, strings.ToLower(field))
+	}
+	return tags
+}
+
+// tagFields splits the tags as they were set.
+func (pc *ParsedConfig) tagFields() []string {
+	return strings.FieldsFunc(pc.Tags, func(r rune) bool {
+		return r == ';' || r == ',' || r == ' '
+	})
+}
+
+// NewService creates a service without IP addresses
+func NewService(id uint64, name string, config map[string]string) Service {
+	return Service{ID: id, Name: name, Config: config, IPs: make([]IP, 0)}
+}
+
+// GetIPs returns the addresses of all interfaces
+func (pai *ParsedAgentInterfaces) GetIPs() []IP {
+	ips := make([]IP, 0)
+	for _, r := range pai.Result {
+		for _, ip := range r.IPAddresses {
+			ip.Interface = r.Name
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,303 @@
+package proxmox
+
+import (
+	"testing"
+)
+
+func TestService_Basics(t *testing.T) {
+	// Create a service directly without calling NewService
+	service := Service{
+		ID:     100,
+		Name:   "test-service",
+		Config: map[string]string{"traefik.enable": "true"},
+		IPs:    make([]IP, 0),
+	}
+
+	// Test basic properties
+	if service.ID != 100 {
+		t.Errorf("Service ID = %v, want %v", service.ID, 100)
+	}
+
+	if service.Name != "test-service" {
+		t.Errorf("Service Name = %v, want %v", service.Name, "test-service")
+	}
+
+	if service.Config["traefik.enable"] != "true" {
+		t.Errorf("Config value = %v, want %v", service.Config["traefik.enable"], "true")
+	}
+
+	if len(service.IPs) != 0 {
+		t.Errorf("Expected empty IPs, got %d items", len(service.IPs))
+	}
+}
+
+func TestService_Config(t *testing.T) {
+	// Create services directly
+	serviceWithEnable := Service{
+		ID:     1,
+		Name:   "enabled-service",
+		Config: map[string]string{"traefik.enable": "true"},
+		IPs:    make([]IP, 0),
+	}
+
+	enableValue, exists := serviceWithEnable.Config["traefik.enable"]
+	if !exists {
+		t.Error("Expected 'traefik.enable' config to exist but it doesn't")
+	}
+	if enableValue != "true" {
+		t.Errorf("Config value = %v, want %v", enableValue, "true")
+	}
+
+	// Test with empty config
+	serviceWithEmptyConfig := Service{
+		ID:     2,
+		Name:   "empty-config-service",
+		Config: map[string]string{},
+		IPs:    make([]IP, 0),
+	}
+
+	_, exists = serviceWithEmptyConfig.Config["traefik.enable"]
+	if exists {
+		t.Error("Didn't expect 'traefik.enable' config to exist but it does")
+	}
+}
+
+func TestService_IPs(t *testing.T) {
+	// Create a service with IPs
+	service := Service{
+		ID:     300,
+		Name:   "ip-service",
+		Config: map[string]string{},
+		IPs: []IP{
+			{Address: "192.168.1.1", AddressType: "ipv4", Prefix: 24},
+		},
+	}
+
+	if len(service.IPs) != 1 {
+		t.Fatalf("Expected 1 IP, got %d", len(service.IPs))
+	}
+
+	if service.IPs[0].Address != "192.168.1.1" {
+		t.Errorf("Expected IP address 192.168.1.1, got %s", service.IPs[0].Address)
+	}
+}
+
+func TestParsedConfig_GetTraefikMap(t *testing.T) {
+	pc := ParsedConfig{
+		Description: "traefik.enable=true\ntraefik.http.routers.test.rule=Host(`test.example.com`)",
+	}
+
+	m := pc.GetTraefikMap()
+
+	if len(m) != 2 {
+		t.Errorf("Expected 2 config items, got %d", len(m))
+	}
+
+	if m["traefik.enable"] != "true" {
+		t.Errorf("Expected traefik.enable=true, got %s", m["traefik.enable"])
+	}
+
+	if m["traefik.http.routers.test.rule"] != "Host(`test.example.com`)" {
+		t.Errorf("Expected correct router rule, got %s", m["traefik.http.routers.test.rule"])
+	}
+}
+
+func TestParsedAgentInterfaces_GetIPs(t *testing.T) {
+	pai := ParsedAgentInterfaces{
+		Result: []AgentInterface{
+			{
+				IPAddresses: []IP{
+					{Address: "192.168.1.1", AddressType: "ipv4", Prefix: 24},
+					{Address: "10.0.0.1", AddressType: "ipv4", Prefix: 16},
+				},
+			},
+		},
+	}
+
+	ips := pai.GetIPs()
+
+	if len(ips) != 2 {
+		t.Errorf("Expected 2 IPs, got %d", len(ips))
+	}
+
+	if ips[0].Address != "192.168.1.1" {
+		t.Errorf("Expected first IP to be 192.168.1.1, got %s", ips[0].Address)
+	}
+
+	if ips[1].Address != "10.0.0.1" {
+		t.Errorf("Expected second IP to be 10.0.0.1, got %s", ips[1].Address)
+	}
+}
+
+func TestParsedConfig_GetStaticIPs(t *testing.T) {
+	pc := NewParsedConfig(map[string]interface{}{
+		"description": "traefik.enable=true",
+		"net0":        "name=eth0,bridge=vmbr0,hwaddr=BC:24:11:00:00:01,ip=10.0.10.5/24,gw=10.0.10.1,ip6=auto",
+		"net1":        "name=eth1,bridge=vmbr1,ip=dhcp,ip6=2001:db8::5/64",
+		"memory":      float64(512),
+	})
+
+	if pc.Description != "traefik.enable=true" {
+		t.Errorf("Expected description to be decoded, got %q", pc.Description)
+	}
+
+	ips := pc.GetStaticIPs()
+	if len(ips) != 2 {
+		t.Fatalf("Expected 2 static IPs, got %+v", ips)
+	}
+	if ips[0].Address != "10.0.10.5" || ips[0].Prefix != 24 || ips[0].Interface != "eth0" || ips[0].AddressType != "ipv4" {
+		t.Errorf("Unexpected first IP %+v", ips[0])
+	}
+	if ips[1].Address != "2001:db8::5" || ips[1].Interface != "eth1" || ips[1].AddressType != "ipv6" {
+		t.Errorf("Unexpected second IP %+v", ips[1])
+	}
+}
+
+func TestParsedConfig_GetStaticIPsCloudInit(t *testing.T) {
+	pc := NewParsedConfig(map[string]interface{}{
+		"net0":      "virtio=BC:24:11:00:00:02,bridge=vmbr0",
+		"ipconfig0": "ip=10.0.10.6/24,gw=10.0.10.1",
+		"ipconfig1": "ip=dhcp",
+	})
+
+	ips := pc.GetStaticIPs()
+	if len(ips) != 1 || ips[0].Address != "10.0.10.6" || ips[0].Prefix != 24 {
+		t.Errorf("Expected the cloud-init address, got %+v", ips)
+	}
+}
+
+func TestVersion_Before(t *testing.T) {
+	tests := []struct {
+		version  Version
+		expected bool
+	}{
+		{Version{Release: "8.1", Version: "8.1.4"}, true},
+		{Version{Release: "8.2", Version: "8.2.2"}, false},
+		{Version{Release: "7.4"}, true},
+		{Version{Release: "9.0"}, false},
+		{Version{Release: "unknown"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.version.Before(8, 2); got != tt.expected {
+			t.Errorf("Before(8, 2) for %+v = %t, want %t", tt.version, got, tt.expected)
+		}
+	}
+}
+
+func TestParsedConfig_GetTagLabels(t *testing.T) {
+	pc := ParsedConfig{
+		Description: "traefik.http.services.web.loadbalancer.server.port=9090",
+		Tags:        "web;traefik.enable+true;traefik.http.services.web.loadbalancer.server.port+8080;traefik.http.routers.web.entrypoints+websecure",
+	}
+
+	tagLabels := pc.GetTagLabels()
+	if len(tagLabels) != 3 {
+		t.Errorf("Expected 3 tag labels, got %d: %v", len(tagLabels), tagLabels)
+	}
+	if tagLabels["traefik.enable"] != "true" {
+		t.Errorf("Expected traefik.enable=true, got %q", tagLabels["traefik.enable"])
+	}
+
+	m := pc.GetTraefikMap()
+	if m["traefik.http.services.web.loadbalancer.server.port"] != "9090" {
+		t.Errorf("Expected the notes to override the tags, got %q", m["traefik.http.services.web.loadbalancer.server.port"])
+	}
+	if m["traefik.http.routers.web.entrypoints"] != "websecure" {
+		t.Errorf("Expected entrypoints from the tags, got %q", m["traefik.http.routers.web.entrypoints"])
+	}
+}
+
+func TestParsedConfig_GetTagLabelsMixedCase(t *testing.T) {
+	pc := ParsedConfig{
+		Description: "traefik.http.routers.myApp.rule=Host(`app.example.com`)",
+		Tags:        "Web;traefik.http.routers.myApp.entrypoints+websecure",
+	}
+
+	m := pc.GetTraefikMap()
+	if m["traefik.http.routers.myApp.entrypoints"] != "websecure" {
+		t.Errorf("Expected the tag to keep the case of the router name, got %v", m)
+	}
+	if _, ok := m["traefik.http.routers.myapp.entrypoints"]; ok {
+		t.Errorf("Expected no lowercased router from the tags, got %v", m)
+	}
+	if tags := pc.GetTags(); len(tags) != 2 || tags[0] != "web" {
+		t.Errorf("Expected the tags to still be lowercased, got %v", tags)
+	}
+}
+
+func TestParsedConfig_GetTraefikMapConfigBlock(t *testing.T) {
+	pc := ParsedConfig{
+		Description: "Web server\n\n```traefik\nenable: true\nhttp:\n  routers:\n    web:\n      rule: Host(`web.example.com`)\n      entryPoints:\n        - websecure\n        - web\n      tls: {}\n  services:\n    web:\n      loadBalancer:\n        servers:\n          - url: http://10.0.0.5:8080\n```\ntraefik.http.routers.web.priority=10",
+	}
+
+	m := pc.GetTraefikMap()
+	expected := map[string]string{
+		"traefik.enable":                                    "true",
+		"traefik.http.routers.web.rule":                     "Host(`web.example.com`)",
+		"traefik.http.routers.web.entryPoints":              "websecure,web",
+		"traefik.http.routers.web.tls":                      "true",
+		"traefik.http.services.web.loadBalancer.server.url": "http://10.0.0.5:8080",
+		"traefik.http.routers.web.priority":                 "10",
+	}
+	if len(m) != len(expected) {
+		t.Errorf("Expected %d labels, got %d: %v", len(expected), len(m), m)
+	}
+	for key, value := range expected {
+		if m[key] != value {
+			t.Errorf("Expected %s=%q, got %q", key, value, m[key])
+		}
+	}
+}
+
+func TestParsedConfig_GetTraefikMapConfigBlockServers(t *testing.T) {
+	pc := ParsedConfig{
+		Description: "```traefik\nhttp:\n  services:\n    web:\n      loadBalancer:\n        servers:\n          - url: http://10.0.0.5:8080\n          - url: http://10.0.0.6:8080\n            weight: 2\n```",
+	}
+
+	m := pc.GetTraefikMap()
+	expected := map[string]string{
+		"traefik.http.services.web.loadBalancer.servers[0].url":    "http://10.0.0.5:8080",
+		"traefik.http.services.web.loadBalancer.servers[1].url":    "http://10.0.0.6:8080",
+		"traefik.http.services.web.loadBalancer.servers[1].weight": "2",
+	}
+	if len(m) != len(expected) {
+		t.Errorf("Expected %d labels, got %d: %v", len(expected), len(m), m)
+	}
+	for key, value := range expected {
+		if m[key] != value {
+			t.Errorf("Expected %s=%q, got %q", key, value, m[key])
+		}
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	text := "# Web server\r\n" +
+		"traefik.enable = true\r\n" +
+		"// traefik.http.routers.old.rule=Host(`old.example.com`)\n" +
+		"traefik.http.routers.web.rule=Host(`web.example.com`) && \\\n" +
+		"    (PathPrefix(`/api`) || Query(`mode=full`))\n" +
+		"traefik.http.middlewares.hdr.headers.customrequestheaders.X-Note=\"hello \\\"world\\\"\"\n" +
+		"traefik.http.middlewares.hdr.headers.customresponseheaders.X-Empty=''\n" +
+		"\"traefik.http.routers.web.entrypoints\"=websecure\n" +
+		"traefik.http.middlewares.auth.basicauth.users=user:$apr1$x$y=\n" +
+		"Not a label = at all\n"
+
+	expected := map[string]string{
+		"traefik.enable":                "true",
+		"traefik.http.routers.web.rule": "Host(`web.example.com`) && (PathPrefix(`/api`) || Query(`mode=full`))",
+		"traefik.http.middlewares.hdr.headers.customrequestheaders.X-Note":   `hello "world"`,
+		"traefik.http.middlewares.hdr.headers.customresponseheaders.X-Empty": "",
+		"traefik.http.routers.web.entrypoints":                               "websecure",
+		"traefik.http.middlewares.auth.basicauth.users":                      "user:$apr1$x$y=",
+	}
+
+	m := ParseLabels(text)
+	if len(m) != len(expected) {
+		t.Errorf("Expected %d labels, got %d: %v", len(expected), len(m), m)
+	}
+	for key, value := range expected {
+		if got, ok := m[key]; !ok || got != value {
+			t.Errorf("Expected %s=%q, got %q", key, value, got)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package proxmox
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span kinds as defined by the OpenTelemetry protocol
+const (
+	SpanKindInternal = 1
+	SpanKindClient   = 3
+)
+
+const (
+	tracerScopeName    = "github.com/NX211/traefik-proxmox-provider"
+	defaultServiceName = "traefik-proxmox-provider"
+	maxPendingSpans    = 4096
+)
+
+type spanContextKey struct{}
+
+// Tracer records spans and exports them to an OpenTelemetry collector using OTLP/HTTP (JSON encoding).
+// A nil *Tracer is valid and records nothing, so callers don't need to check whether tracing is enabled.
+type Tracer struct {
+	endpoint    string
+	serviceName string
+	httpClient  *http.Client
+
+	mu      sync.Mutex
+	pending []*Span
+}
+
+// Span represents a single timed operation
+type Span struct {
+	tracer     *Tracer
+	name       string
+	kind       int
+	traceID    string
+	spanID     string
+	parentID   string
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	err        error
+}
+
+// NewTracer creates a tracer exporting to the given OTLP/HTTP traces endpoint,
+// e.g. http://otel-collector:4318/v1/traces
+func NewTracer(endpoint string) *Tracer {
+	return &Tracer{
+		endpoint:    endpoint,
+		serviceName: defaultServiceName,
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Start begins a new span as a child of the span stored in ctx, if any
+func (t *Tracer) Start(ctx context.Context, name string, kind int, attributes map[string]string) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		tracer:     t,
+		name:       name,
+		kind:       kind,
+		spanID:     randomHex(8),
+		start:      time.Now(),
+		attributes: make(map[string]string, len(attributes)),
+	}
+	for k, v := range attributes {
+		span.attributes[k] = v
+	}
+
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else {
+		span.traceID = randomHex(16)
+	}
+
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// SpanFromContext returns the span stored in ctx, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// SetAttribute adds or replaces an attribute on the span
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.attributes[key] = value
+}
+
+// RecordError marks the span as failed
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.err = err
+}
+
+// End finishes the span and queues it for export
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+
+	t := s.tracer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.pending) < maxPendingSpans {
+		t.pending = append(t.pending, s)
+	}
+}
+
+// Flush exports all finished spans to the collector
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(t.buildPayload(spans))
+	if err != nil {
+		return fmt.Errorf("failed to marshal spans: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create trace export request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to export spans: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("trace export failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+	return nil
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+func (t *Tracer) buildPayload(spans []*Span) map[string]interface{} {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		out := otlpSpan{
+			TraceID:           s.traceID,
+			SpanID:            s.spanID,
+			ParentSpanID:      s.parentID,
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        toOTLPAttributes(s.attributes),
+			Status:            otlpStatus{Code: 1},
+		}
+		if s.err != nil {
+			out.Status = otlpStatus{Code: 2, Message: s.err.Error()}
+		}
+		otlpSpans = append(otlpSpans, out)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": toOTLPAttributes(map[string]string{"service.name": t.serviceName}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": tracerScopeName},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+}
+
+func toOTLPAttributes(attributes map[string]string) []otlpAttribute {
+	result := make([]otlpAttribute, 0, len(attributes))
+	for k, v := range attributes {
+		attr := otlpAttribute{Key: k}
+		attr.Value.StringValue = v
+		result = append(result, attr)
+	}
+	return result
+}
+
+// apiSpanName turns an API path into a low-cardinality span name, e.g. GET /nodes/{node}/qemu/{vmid}/config
+func apiSpanName(method, path string) string {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i := range parts {
+		if i > 0 && parts[i-1] == "nodes" {
+			parts[i] = "{node}"
+		}
+		if i > 0 && (parts[i-1] == "qemu" || parts[i-1] == "lxc") {
+			parts[i] = "{vmid}"
+		}
+	}
+	return method + " /" + strings.Join(parts, "/")
+}
+
+// apiSpanAttributes extracts the node and guest identifiers from an API path
+func apiSpanAttributes(method, path string) map[string]string {
+	attributes := map[string]string{
+		"http.request.method": method,
+		"url.path":            path,
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i := 1; i < len(parts); i++ {
+		switch parts[i-1] {
+		case "nodes":
+			attributes["proxmox.node"] = parts[i]
+		case "qemu", "lxc":
+			attributes["proxmox.vmid"] = parts[i]
+		}
+	}
+	return attributes
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,84 @@
+package proxmox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTracer_NilIsNoop(t *testing.T) {
+	var tracer *Tracer
+	ctx, span := tracer.Start(context.Background(), "noop", SpanKindInternal, nil)
+	span.SetAttribute("key", "value")
+	span.RecordError(errors.New("ignored"))
+	span.End()
+
+	if span != nil {
+		t.Errorf("Expected nil span from nil tracer")
+	}
+	if SpanFromContext(ctx) != nil {
+		t.Errorf("Expected no span in context")
+	}
+	if err := tracer.Flush(ctx); err != nil {
+		t.Errorf("Flush() error = %v", err)
+	}
+}
+
+func TestTracer_Export(t *testing.T) {
+	var payload struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []otlpSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+			t.Errorf("Failed to decode payload: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	tracer := NewTracer(server.URL)
+	ctx, parent := tracer.Start(context.Background(), "poll", SpanKindInternal, nil)
+	_, child := tracer.Start(ctx, "GET /nodes", SpanKindClient, map[string]string{"proxmox.node": "pve1"})
+	child.RecordError(errors.New("timeout"))
+	child.End()
+	parent.End()
+
+	if err := tracer.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	spans := payload.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].TraceID != spans[1].TraceID {
+		t.Errorf("Expected spans to share a trace ID")
+	}
+	if spans[0].ParentSpanID != spans[1].SpanID {
+		t.Errorf("Expected child span to reference its parent")
+	}
+	if spans[0].Status.Code != 2 || spans[0].Status.Message != "timeout" {
+		t.Errorf("Expected error status on child span, got %+v", spans[0].Status)
+	}
+}
+
+func TestApiSpanName(t *testing.T) {
+	tests := map[string]string{
+		"/version":                       "GET /version",
+		"/nodes/pve1/qemu":               "GET /nodes/{node}/qemu",
+		"/nodes/pve1/qemu/105/config":    "GET /nodes/{node}/qemu/{vmid}/config",
+		"/nodes/pve2/lxc/200/interfaces": "GET /nodes/{node}/lxc/{vmid}/interfaces",
+	}
+	for path, expected := range tests {
+		if got := apiSpanName("GET", path); got != expected {
+			t.Errorf("apiSpanName(%s) = %s, want %s", path, got, expected)
+		}
+	}
+}
@@ -10,24 +10,180 @@ import (
 
 // Config the plugin configuration.
 type Config struct {
-	PollInterval   string `json:"pollInterval" yaml:"pollInterval" toml:"pollInterval"`
-	ApiEndpoint    string `json:"apiEndpoint" yaml:"apiEndpoint" toml:"apiEndpoint"`
-	ApiTokenId     string `json:"apiTokenId" yaml:"apiTokenId" toml:"apiTokenId"`
-	ApiToken       string `json:"apiToken" yaml:"apiToken" toml:"apiToken"`
-	ApiLogging     string `json:"apiLogging" yaml:"apiLogging" toml:"apiLogging"`
-	ApiValidateSSL string `json:"apiValidateSSL" yaml:"apiValidateSSL" toml:"apiValidateSSL"`
+	PollInterval            string            `json:"pollInterval" yaml:"pollInterval" toml:"pollInterval"`
+	ApiEndpoint             string            `json:"apiEndpoint" yaml:"apiEndpoint" toml:"apiEndpoint"`
+	ApiTokenId              string            `json:"apiTokenId" yaml:"apiTokenId" toml:"apiTokenId"`
+	ApiToken                string            `json:"apiToken" yaml:"apiToken" toml:"apiToken"`
+	ApiLogging              string            `json:"apiLogging" yaml:"apiLogging" toml:"apiLogging"`
+	ApiValidateSSL          string            `json:"apiValidateSSL" yaml:"apiValidateSSL" toml:"apiValidateSSL"`
+	ListenAddress           string            `json:"listenAddress,omitempty" yaml:"listenAddress,omitempty" toml:"listenAddress,omitempty"`
+	TracingEndpoint         string            `json:"tracingEndpoint,omitempty" yaml:"tracingEndpoint,omitempty" toml:"tracingEndpoint,omitempty"`
+	OutputFile              string            `json:"outputFile,omitempty" yaml:"outputFile,omitempty" toml:"outputFile,omitempty"`
+	OutputFormat            string            `json:"outputFormat,omitempty" yaml:"outputFormat,omitempty" toml:"outputFormat,omitempty"`
+	KVEndpoint              string            `json:"kvEndpoint,omitempty" yaml:"kvEndpoint,omitempty" toml:"kvEndpoint,omitempty"`
+	KVRootKey               string            `json:"kvRootKey,omitempty" yaml:"kvRootKey,omitempty" toml:"kvRootKey,omitempty"`
+	OutputPrivateKeys       string            `json:"outputPrivateKeys,omitempty" yaml:"outputPrivateKeys,omitempty" toml:"outputPrivateKeys,omitempty"`
+	Nodes                   []string          `json:"nodes,omitempty" yaml:"nodes,omitempty" toml:"nodes,omitempty"`
+	ExcludeNodes            []string          `json:"excludeNodes,omitempty" yaml:"excludeNodes,omitempty" toml:"excludeNodes,omitempty"`
+	NodeRefreshInterval     string            `json:"nodeRefreshInterval,omitempty" yaml:"nodeRefreshInterval,omitempty" toml:"nodeRefreshInterval,omitempty"`
+	Pools                   []string          `json:"pools,omitempty" yaml:"pools,omitempty" toml:"pools,omitempty"`
+	ExcludePools            []string          `json:"excludePools,omitempty" yaml:"excludePools,omitempty" toml:"excludePools,omitempty"`
+	Tags                    []string          `json:"tags,omitempty" yaml:"tags,omitempty" toml:"tags,omitempty"`
+	ExcludeTags             []string          `json:"excludeTags,omitempty" yaml:"excludeTags,omitempty" toml:"excludeTags,omitempty"`
+	VMIDRanges              string            `json:"vmidRanges,omitempty" yaml:"vmidRanges,omitempty" toml:"vmidRanges,omitempty"`
+	NameFilter              string            `json:"nameFilter,omitempty" yaml:"nameFilter,omitempty" toml:"nameFilter,omitempty"`
+	NameExclude             string            `json:"nameExclude,omitempty" yaml:"nameExclude,omitempty" toml:"nameExclude,omitempty"`
+	GuestTypes              []string          `json:"guestTypes,omitempty" yaml:"guestTypes,omitempty" toml:"guestTypes,omitempty"`
+	IncludeTemplates        string            `json:"includeTemplates,omitempty" yaml:"includeTemplates,omitempty" toml:"includeTemplates,omitempty"`
+	IncludeLocked           string            `json:"includeLocked,omitempty" yaml:"includeLocked,omitempty" toml:"includeLocked,omitempty"`
+	IncludeStopped          string            `json:"includeStopped,omitempty" yaml:"includeStopped,omitempty" toml:"includeStopped,omitempty"`
+	RequireAgentPing        string            `json:"requireAgentPing,omitempty" yaml:"requireAgentPing,omitempty" toml:"requireAgentPing,omitempty"`
+	AgentTimeout            string            `json:"agentTimeout,omitempty" yaml:"agentTimeout,omitempty" toml:"agentTimeout,omitempty"`
+	MaintenanceNodes        []string          `json:"maintenanceNodes,omitempty" yaml:"maintenanceNodes,omitempty" toml:"maintenanceNodes,omitempty"`
+	HAMaintenance           string            `json:"haMaintenance,omitempty" yaml:"haMaintenance,omitempty" toml:"haMaintenance,omitempty"`
+	MaintenanceMode         string            `json:"maintenanceMode,omitempty" yaml:"maintenanceMode,omitempty" toml:"maintenanceMode,omitempty"`
+	IPFamily                string            `json:"ipFamily,omitempty" yaml:"ipFamily,omitempty" toml:"ipFamily,omitempty"`
+	DefaultInterface        string            `json:"defaultInterface,omitempty" yaml:"defaultInterface,omitempty" toml:"defaultInterface,omitempty"`
+	PreferredCIDRs          []string          `json:"preferredCIDRs,omitempty" yaml:"preferredCIDRs,omitempty" toml:"preferredCIDRs,omitempty"`
+	ExcludedCIDRs           []string          `json:"excludedCIDRs,omitempty" yaml:"excludedCIDRs,omitempty" toml:"excludedCIDRs,omitempty"`
+	OverrideExcludedCIDRs   string            `json:"overrideExcludedCIDRs,omitempty" yaml:"overrideExcludedCIDRs,omitempty" toml:"overrideExcludedCIDRs,omitempty"`
+	LocalNeighborTable      string            `json:"localNeighborTable,omitempty" yaml:"localNeighborTable,omitempty" toml:"localNeighborTable,omitempty"`
+	LocalDnsmasqLeases      string            `json:"localDnsmasqLeases,omitempty" yaml:"localDnsmasqLeases,omitempty" toml:"localDnsmasqLeases,omitempty"`
+	DHCPLeases              string            `json:"dhcpLeases,omitempty" yaml:"dhcpLeases,omitempty" toml:"dhcpLeases,omitempty"`
+	SDNIPAM                 string            `json:"sdnIpam,omitempty" yaml:"sdnIpam,omitempty" toml:"sdnIpam,omitempty"`
+	IPCacheTTL              string            `json:"ipCacheTTL,omitempty" yaml:"ipCacheTTL,omitempty" toml:"ipCacheTTL,omitempty"`
+	IPRefreshInterval       string            `json:"ipRefreshInterval,omitempty" yaml:"ipRefreshInterval,omitempty" toml:"ipRefreshInterval,omitempty"`
+	UseGuestHostname        string            `json:"useGuestHostname,omitempty" yaml:"useGuestHostname,omitempty" toml:"useGuestHostname,omitempty"`
+	GuestLabelFile          string            `json:"guestLabelFile,omitempty" yaml:"guestLabelFile,omitempty" toml:"guestLabelFile,omitempty"`
+	PublishAll              string            `json:"publishAll,omitempty" yaml:"publishAll,omitempty" toml:"publishAll,omitempty"`
+	NATMode                 string            `json:"natMode,omitempty" yaml:"natMode,omitempty" toml:"natMode,omitempty"`
+	NodeAddresses           map[string]string `json:"nodeAddresses,omitempty" yaml:"nodeAddresses,omitempty" toml:"nodeAddresses,omitempty"`
+	NATPorts                map[string]string `json:"natPorts,omitempty" yaml:"natPorts,omitempty" toml:"natPorts,omitempty"`
+	HostnameFallback        string            `json:"hostnameFallback,omitempty" yaml:"hostnameFallback,omitempty" toml:"hostnameFallback,omitempty"`
+	DefaultDomain           string            `json:"defaultDomain,omitempty" yaml:"defaultDomain,omitempty" toml:"defaultDomain,omitempty"`
+	NodeDomains             map[string]string `json:"nodeDomains,omitempty" yaml:"nodeDomains,omitempty" toml:"nodeDomains,omitempty"`
+	PoolDomains             map[string]string `json:"poolDomains,omitempty" yaml:"poolDomains,omitempty" toml:"poolDomains,omitempty"`
+	SnippetPaths            map[string]string `json:"snippetPaths,omitempty" yaml:"snippetPaths,omitempty" toml:"snippetPaths,omitempty"`
+	Strict                  string            `json:"strict,omitempty" yaml:"strict,omitempty" toml:"strict,omitempty"`
+	DefaultEntryPoints      []string          `json:"defaultEntryPoints,omitempty" yaml:"defaultEntryPoints,omitempty" toml:"defaultEntryPoints,omitempty"`
+	PortEntryPoints         map[string]string `json:"portEntryPoints,omitempty" yaml:"portEntryPoints,omitempty" toml:"portEntryPoints,omitempty"`
+	DefaultMiddlewares      []string          `json:"defaultMiddlewares,omitempty" yaml:"defaultMiddlewares,omitempty" toml:"defaultMiddlewares,omitempty"`
+	DefaultCertResolver     string            `json:"defaultCertResolver,omitempty" yaml:"defaultCertResolver,omitempty" toml:"defaultCertResolver,omitempty"`
+	DefaultTLSDomain        string            `json:"defaultTLSDomain,omitempty" yaml:"defaultTLSDomain,omitempty" toml:"defaultTLSDomain,omitempty"`
+	DefaultTLSSANs          []string          `json:"defaultTLSSANs,omitempty" yaml:"defaultTLSSANs,omitempty" toml:"defaultTLSSANs,omitempty"`
+	HTTPSRedirect           string            `json:"httpsRedirect,omitempty" yaml:"httpsRedirect,omitempty" toml:"httpsRedirect,omitempty"`
+	HTTPSRedirectEntryPoint string            `json:"httpsRedirectEntryPoint,omitempty" yaml:"httpsRedirectEntryPoint,omitempty" toml:"httpsRedirectEntryPoint,omitempty"`
+	DefaultTCPMiddlewares   []string          `json:"defaultTCPMiddlewares,omitempty" yaml:"defaultTCPMiddlewares,omitempty" toml:"defaultTCPMiddlewares,omitempty"`
+	DefaultHealthCheck      map[string]string `json:"defaultHealthCheck,omitempty" yaml:"defaultHealthCheck,omitempty" toml:"defaultHealthCheck,omitempty"`
+	DefaultSticky           map[string]string `json:"defaultSticky,omitempty" yaml:"defaultSticky,omitempty" toml:"defaultSticky,omitempty"`
+	AllIPs                  string            `json:"allIPs,omitempty" yaml:"allIPs,omitempty" toml:"allIPs,omitempty"`
+	MaintenanceURL          string            `json:"maintenanceURL,omitempty" yaml:"maintenanceURL,omitempty" toml:"maintenanceURL,omitempty"`
+	Namespace               string            `json:"namespace,omitempty" yaml:"namespace,omitempty" toml:"namespace,omitempty"`
+	LegacyPriority          string            `json:"legacyPriority,omitempty" yaml:"legacyPriority,omitempty" toml:"legacyPriority,omitempty"`
+	PassHostHeader          string            `json:"passHostHeader,omitempty" yaml:"passHostHeader,omitempty" toml:"passHostHeader,omitempty"`
+	Protocols               []string          `json:"protocols,omitempty" yaml:"protocols,omitempty" toml:"protocols,omitempty"`
+	TraefikVersion          string            `json:"traefikVersion,omitempty" yaml:"traefikVersion,omitempty" toml:"traefikVersion,omitempty"`
+	ExposeProxmoxUI         string            `json:"exposeProxmoxUI,omitempty" yaml:"exposeProxmoxUI,omitempty" toml:"exposeProxmoxUI,omitempty"`
+	ProxmoxUIConsoles       string            `json:"proxmoxUIConsoles,omitempty" yaml:"proxmoxUIConsoles,omitempty" toml:"proxmoxUIConsoles,omitempty"`
+	SpiceEntryPoint         string            `json:"spiceEntryPoint,omitempty" yaml:"spiceEntryPoint,omitempty" toml:"spiceEntryPoint,omitempty"`
+	ProbeServers            string            `json:"probeServers,omitempty" yaml:"probeServers,omitempty" toml:"probeServers,omitempty"`
+	ProbeTimeout            string            `json:"probeTimeout,omitempty" yaml:"probeTimeout,omitempty" toml:"probeTimeout,omitempty"`
+	ProbeScheme             string            `json:"probeScheme,omitempty" yaml:"probeScheme,omitempty" toml:"probeScheme,omitempty"`
+	ProbeSchemeTransport    string            `json:"probeSchemeTransport,omitempty" yaml:"probeSchemeTransport,omitempty" toml:"probeSchemeTransport,omitempty"`
+	Defaults                map[string]string `json:"defaults,omitempty" yaml:"defaults,omitempty" toml:"defaults,omitempty"`
+	NodeLabels              map[string]string `json:"nodeLabels,omitempty" yaml:"nodeLabels,omitempty" toml:"nodeLabels,omitempty"`
+	PoolLabels              map[string]string `json:"poolLabels,omitempty" yaml:"poolLabels,omitempty" toml:"poolLabels,omitempty"`
+	PoolCommentLabels       string            `json:"poolCommentLabels,omitempty" yaml:"poolCommentLabels,omitempty" toml:"poolCommentLabels,omitempty"`
 }
 
 // CreateConfig creates the default plugin configuration.
 func CreateConfig() *Config {
 	cfg := provider.CreateConfig()
 	return &Config{
-		PollInterval:   cfg.PollInterval,
-		ApiEndpoint:    cfg.ApiEndpoint,
-		ApiTokenId:     cfg.ApiTokenId,
-		ApiToken:       cfg.ApiToken,
-		ApiLogging:     cfg.ApiLogging,
-		ApiValidateSSL: cfg.ApiValidateSSL,
+		PollInterval:            cfg.PollInterval,
+		ApiEndpoint:             cfg.ApiEndpoint,
+		ApiTokenId:              cfg.ApiTokenId,
+		ApiToken:                cfg.ApiToken,
+		ApiLogging:              cfg.ApiLogging,
+		ApiValidateSSL:          cfg.ApiValidateSSL,
+		ListenAddress:           cfg.ListenAddress,
+		TracingEndpoint:         cfg.TracingEndpoint,
+		OutputFile:              cfg.OutputFile,
+		OutputFormat:            cfg.OutputFormat,
+		KVEndpoint:              cfg.KVEndpoint,
+		KVRootKey:               cfg.KVRootKey,
+		OutputPrivateKeys:       cfg.OutputPrivateKeys,
+		Nodes:                   cfg.Nodes,
+		ExcludeNodes:            cfg.ExcludeNodes,
+		NodeRefreshInterval:     cfg.NodeRefreshInterval,
+		Pools:                   cfg.Pools,
+		ExcludePools:            cfg.ExcludePools,
+		Tags:                    cfg.Tags,
+		ExcludeTags:             cfg.ExcludeTags,
+		VMIDRanges:              cfg.VMIDRanges,
+		NameFilter:              cfg.NameFilter,
+		NameExclude:             cfg.NameExclude,
+		GuestTypes:              cfg.GuestTypes,
+		IncludeTemplates:        cfg.IncludeTemplates,
+		IncludeLocked:           cfg.IncludeLocked,
+		IncludeStopped:          cfg.IncludeStopped,
+		RequireAgentPing:        cfg.RequireAgentPing,
+		AgentTimeout:            cfg.AgentTimeout,
+		MaintenanceNodes:        cfg.MaintenanceNodes,
+		HAMaintenance:           cfg.HAMaintenance,
+		MaintenanceMode:         cfg.MaintenanceMode,
+		IPFamily:                cfg.IPFamily,
+		DefaultInterface:        cfg.DefaultInterface,
+		PreferredCIDRs:          cfg.PreferredCIDRs,
+		ExcludedCIDRs:           cfg.ExcludedCIDRs,
+		OverrideExcludedCIDRs:   cfg.OverrideExcludedCIDRs,
+		LocalNeighborTable:      cfg.LocalNeighborTable,
+		LocalDnsmasqLeases:      cfg.LocalDnsmasqLeases,
+		DHCPLeases:              cfg.DHCPLeases,
+		SDNIPAM:                 cfg.SDNIPAM,
+		IPCacheTTL:              cfg.IPCacheTTL,
+		IPRefreshInterval:       cfg.IPRefreshInterval,
+		UseGuestHostname:        cfg.UseGuestHostname,
+		GuestLabelFile:          cfg.GuestLabelFile,
+		PublishAll:              cfg.PublishAll,
+		NATMode:                 cfg.NATMode,
+		NodeAddresses:           cfg.NodeAddresses,
+		NATPorts:                cfg.NATPorts,
+		HostnameFallback:        cfg.HostnameFallback,
+		DefaultDomain:           cfg.DefaultDomain,
+		NodeDomains:             cfg.NodeDomains,
+		PoolDomains:             cfg.PoolDomains,
+		SnippetPaths:            cfg.SnippetPaths,
+		Strict:                  cfg.Strict,
+		DefaultEntryPoints:      cfg.DefaultEntryPoints,
+		PortEntryPoints:         cfg.PortEntryPoints,
+		DefaultMiddlewares:      cfg.DefaultMiddlewares,
+		DefaultCertResolver:     cfg.DefaultCertResolver,
+		DefaultTLSDomain:        cfg.DefaultTLSDomain,
+		DefaultTLSSANs:          cfg.DefaultTLSSANs,
+		HTTPSRedirect:           cfg.HTTPSRedirect,
+		HTTPSRedirectEntryPoint: cfg.HTTPSRedirectEntryPoint,
+		DefaultTCPMiddlewares:   cfg.DefaultTCPMiddlewares,
+		DefaultHealthCheck:      cfg.DefaultHealthCheck,
+		DefaultSticky:           cfg.DefaultSticky,
+		AllIPs:                  cfg.AllIPs,
+		MaintenanceURL:          cfg.MaintenanceURL,
+		Namespace:               cfg.Namespace,
+		LegacyPriority:          cfg.LegacyPriority,
+		PassHostHeader:          cfg.PassHostHeader,
+		Protocols:               cfg.Protocols,
+		TraefikVersion:          cfg.TraefikVersion,
+		ExposeProxmoxUI:         cfg.ExposeProxmoxUI,
+		ProxmoxUIConsoles:       cfg.ProxmoxUIConsoles,
+		SpiceEntryPoint:         cfg.SpiceEntryPoint,
+		ProbeServers:            cfg.ProbeServers,
+		ProbeTimeout:            cfg.ProbeTimeout,
+		ProbeScheme:             cfg.ProbeScheme,
+		ProbeSchemeTransport:    cfg.ProbeSchemeTransport,
+		Defaults:                cfg.Defaults,
+		NodeLabels:              cfg.NodeLabels,
+		PoolLabels:              cfg.PoolLabels,
+		PoolCommentLabels:       cfg.PoolCommentLabels,
 	}
 }
 
@@ -39,12 +195,90 @@ type Provider struct {
 // New creates a new Provider plugin.
 func New(ctx context.Context, config *Config, name string) (*Provider, error) {
 	providerConfig := &provider.Config{
-		PollInterval:   config.PollInterval,
-		ApiEndpoint:    config.ApiEndpoint,
-		ApiTokenId:     config.ApiTokenId,
-		ApiToken:       config.ApiToken,
-		ApiLogging:     config.ApiLogging,
-		ApiValidateSSL: config.ApiValidateSSL,
+		PollInterval:            config.PollInterval,
+		ApiEndpoint:             config.ApiEndpoint,
+		ApiTokenId:              config.ApiTokenId,
+		ApiToken:                config.ApiToken,
+		ApiLogging:              config.ApiLogging,
+		ApiValidateSSL:          config.ApiValidateSSL,
+		ListenAddress:           config.ListenAddress,
+		TracingEndpoint:         config.TracingEndpoint,
+		OutputFile:              config.OutputFile,
+		OutputFormat:            config.OutputFormat,
+		KVEndpoint:              config.KVEndpoint,
+		KVRootKey:               config.KVRootKey,
+		OutputPrivateKeys:       config.OutputPrivateKeys,
+		Nodes:                   config.Nodes,
+		ExcludeNodes:            config.ExcludeNodes,
+		NodeRefreshInterval:     config.NodeRefreshInterval,
+		Pools:                   config.Pools,
+		ExcludePools:            config.ExcludePools,
+		Tags:                    config.Tags,
+		ExcludeTags:             config.ExcludeTags,
+		VMIDRanges:              config.VMIDRanges,
+		NameFilter:              config.NameFilter,
+		NameExclude:             config.NameExclude,
+		GuestTypes:              config.GuestTypes,
+		IncludeTemplates:        config.IncludeTemplates,
+		IncludeLocked:           config.IncludeLocked,
+		IncludeStopped:          config.IncludeStopped,
+		RequireAgentPing:        config.RequireAgentPing,
+		AgentTimeout:            config.AgentTimeout,
+		MaintenanceNodes:        config.MaintenanceNodes,
+		HAMaintenance:           config.HAMaintenance,
+		MaintenanceMode:         config.MaintenanceMode,
+		IPFamily:                config.IPFamily,
+		DefaultInterface:        config.DefaultInterface,
+		PreferredCIDRs:          config.PreferredCIDRs,
+		ExcludedCIDRs:           config.ExcludedCIDRs,
+		OverrideExcludedCIDRs:   config.OverrideExcludedCIDRs,
+		LocalNeighborTable:      config.LocalNeighborTable,
+		LocalDnsmasqLeases:      config.LocalDnsmasqLeases,
+		DHCPLeases:              config.DHCPLeases,
+		SDNIPAM:                 config.SDNIPAM,
+		IPCacheTTL:              config.IPCacheTTL,
+		IPRefreshInterval:       config.IPRefreshInterval,
+		UseGuestHostname:        config.UseGuestHostname,
+		GuestLabelFile:          config.GuestLabelFile,
+		PublishAll:              config.PublishAll,
+		NATMode:                 config.NATMode,
+		NodeAddresses:           config.NodeAddresses,
+		NATPorts:                config.NATPorts,
+		HostnameFallback:        config.HostnameFallback,
+		DefaultDomain:           config.DefaultDomain,
+		NodeDomains:             config.NodeDomains,
+		PoolDomains:             config.PoolDomains,
+		SnippetPaths:            config.SnippetPaths,
+		Strict:                  config.Strict,
+		DefaultEntryPoints:      config.DefaultEntryPoints,
+		PortEntryPoints:         config.PortEntryPoints,
+		DefaultMiddlewares:      config.DefaultMiddlewares,
+		DefaultCertResolver:     config.DefaultCertResolver,
+		DefaultTLSDomain:        config.DefaultTLSDomain,
+		DefaultTLSSANs:          config.DefaultTLSSANs,
+		HTTPSRedirect:           config.HTTPSRedirect,
+		HTTPSRedirectEntryPoint: config.HTTPSRedirectEntryPoint,
+		DefaultTCPMiddlewares:   config.DefaultTCPMiddlewares,
+		DefaultHealthCheck:      config.DefaultHealthCheck,
+		DefaultSticky:           config.DefaultSticky,
+		AllIPs:                  config.AllIPs,
+		MaintenanceURL:          config.MaintenanceURL,
+		Namespace:               config.Namespace,
+		LegacyPriority:          config.LegacyPriority,
+		PassHostHeader:          config.PassHostHeader,
+		Protocols:               config.Protocols,
+		TraefikVersion:          config.TraefikVersion,
+		ExposeProxmoxUI:         config.ExposeProxmoxUI,
+		ProxmoxUIConsoles:       config.ProxmoxUIConsoles,
+		SpiceEntryPoint:         config.SpiceEntryPoint,
+		ProbeServers:            config.ProbeServers,
+		ProbeTimeout:            config.ProbeTimeout,
+		ProbeScheme:             config.ProbeScheme,
+		ProbeSchemeTransport:    config.ProbeSchemeTransport,
+		Defaults:                config.Defaults,
+		NodeLabels:              config.NodeLabels,
+		PoolLabels:              config.PoolLabels,
+		PoolCommentLabels:       config.PoolCommentLabels,
 	}
 
 	innerProvider, err := provider.New(ctx, providerConfig, name)
@@ -70,4 +304,4 @@ func (p *Provider) Provide(cfgChan chan<- json.Marshaler) error {
 // Stop the provider.
 func (p *Provider) Stop() error {
 	return p.provider.Stop()
-} 
\ No newline at end of file
+}